@@ -45,21 +45,116 @@ func (c *Completion) Execute() (bool, error) {
 			},
 			"render": complete.Command{
 				Flags: complete.Flags{
-					"--allow-fs-access": complete.PredictNothing,
-					"--chroot":          complete.PredictDirs("*"),
-					"-c":                complete.PredictDirs("*"),
-					"--freeze":          complete.PredictNothing,
-					"-z":                complete.PredictNothing,
-					"--freeze-list":     complete.PredictAnything,
-					"--freeze-ref":      complete.PredictFiles("*"),
-					"--input":           complete.PredictFiles("*"),
-					"-i":                complete.PredictFiles("*"),
-					"--output":          complete.PredictFiles("*"),
-					"-o":                complete.PredictFiles("*"),
-					"--set":             complete.PredictAnything,
-					"-s":                complete.PredictAnything,
-					"--syntax":          complete.PredictSet("$", "go-template", "kind-template"),
-					"-x":                complete.PredictSet("$", "go-template", "kind-template"),
+					"--lib":                  complete.PredictDirs("*"),
+					"--allow-fs-access":      complete.PredictNothing,
+					"--chroot":               complete.PredictDirs("*"),
+					"--diff-against":         complete.PredictAnything,
+					"--env":                  complete.PredictAnything,
+					"--dotenv-interpolate":   complete.PredictNothing,
+					"--env-keep-prefix":      complete.PredictNothing,
+					"--env-prefix":           complete.PredictAnything,
+					"--from-env":             complete.PredictAnything,
+					"--kubeconfig":           complete.PredictFiles("*"),
+					"-c":                     complete.PredictDirs("*"),
+					"--fail-on-unknown-keys": complete.PredictNothing,
+					"--freeze":               complete.PredictNothing,
+					"--freeze-path":          complete.PredictAnything,
+					"--freeze-paths-file":    complete.PredictFiles("*"),
+					"--freeze-hash":          complete.PredictSet("object", "data", "kustomize"),
+					"--freeze-hash-length":   complete.PredictAnything,
+					"--freeze-name-template": complete.PredictAnything,
+					"-z":                     complete.PredictNothing,
+					"--freeze-list":          complete.PredictAnything,
+					"--freeze-ref":           complete.PredictFiles("*"),
+					"--input":                complete.PredictFiles("*"),
+					"-i":                     complete.PredictFiles("*"),
+					"--node-aware":           complete.PredictNothing,
+					"--output":               complete.PredictFiles("*"),
+					"--output-dir":           complete.PredictDirs("*"),
+					"--output-dir-clean":     complete.PredictNothing,
+					"--output-format":        complete.PredictSet("yaml", "json", "json-lines", "list"),
+					"--output-name-pattern":  complete.PredictAnything,
+					"-o":                     complete.PredictFiles("*"),
+					"--list-merge":           complete.PredictSet("replace", "append", "merge-by-key"),
+					"--list-merge-key":       complete.PredictAnything,
+					"--set":                  complete.PredictAnything,
+					"--set-json":             complete.PredictAnything,
+					"--set-string":           complete.PredictAnything,
+					"-s":                     complete.PredictAnything,
+					"--syntax":               complete.PredictSet("$", "go-template", "kind-template"),
+					"-x":                     complete.PredictSet("$", "go-template", "kind-template"),
+					"--watch":                complete.PredictNothing,
+					"-w":                     complete.PredictNothing,
+					"--watch-debounce":       complete.PredictAnything,
+				},
+				Args: complete.PredictFiles("*"),
+			},
+			"check": complete.Command{
+				Flags: complete.Flags{
+					"--lib":                complete.PredictDirs("*"),
+					"--env":                complete.PredictAnything,
+					"--dotenv-interpolate": complete.PredictNothing,
+					"--env-keep-prefix":    complete.PredictNothing,
+					"--env-prefix":         complete.PredictAnything,
+					"--from-env":           complete.PredictAnything,
+					"--input":              complete.PredictFiles("*"),
+					"-i":                   complete.PredictFiles("*"),
+					"--list-merge":         complete.PredictSet("replace", "append", "merge-by-key"),
+					"--list-merge-key":     complete.PredictAnything,
+					"--output-format":      complete.PredictSet("text", "json"),
+					"--set":                complete.PredictAnything,
+					"--set-json":           complete.PredictAnything,
+					"--set-string":         complete.PredictAnything,
+					"-s":                   complete.PredictAnything,
+					"--syntax":             complete.PredictSet("$", "go-template", "kind-template"),
+					"-x":                   complete.PredictSet("$", "go-template", "kind-template"),
+				},
+				Args: complete.PredictFiles("*"),
+			},
+			"diff": complete.Command{
+				Flags: complete.Flags{
+					"--lib":                  complete.PredictDirs("*"),
+					"--allow-fs-access":      complete.PredictNothing,
+					"--chroot":               complete.PredictDirs("*"),
+					"-c":                     complete.PredictDirs("*"),
+					"--env":                  complete.PredictAnything,
+					"--dotenv-interpolate":   complete.PredictNothing,
+					"--env-keep-prefix":      complete.PredictNothing,
+					"--env-prefix":           complete.PredictAnything,
+					"--from-env":             complete.PredictAnything,
+					"--fail-on-unknown-keys": complete.PredictNothing,
+					"--freeze":               complete.PredictNothing,
+					"--freeze-path":          complete.PredictAnything,
+					"--freeze-paths-file":    complete.PredictFiles("*"),
+					"--freeze-hash":          complete.PredictSet("object", "data", "kustomize"),
+					"--freeze-hash-length":   complete.PredictAnything,
+					"--freeze-name-template": complete.PredictAnything,
+					"-z":                     complete.PredictNothing,
+					"--input":                complete.PredictFiles("*"),
+					"-i":                     complete.PredictFiles("*"),
+					"--list-merge":           complete.PredictSet("replace", "append", "merge-by-key"),
+					"--list-merge-key":       complete.PredictAnything,
+					"--output-format":        complete.PredictSet("text", "json"),
+					"--set":                  complete.PredictAnything,
+					"--set-json":             complete.PredictAnything,
+					"--set-string":           complete.PredictAnything,
+					"-s":                     complete.PredictAnything,
+					"--syntax":               complete.PredictSet("$", "go-template", "kind-template"),
+					"-x":                     complete.PredictSet("$", "go-template", "kind-template"),
+					"--to-env":               complete.PredictAnything,
+					"--to-input":             complete.PredictFiles("*"),
+					"--to-set":               complete.PredictAnything,
+					"--to-set-json":          complete.PredictAnything,
+					"--to-set-string":        complete.PredictAnything,
+				},
+				Args: complete.PredictFiles("*"),
+			},
+			"vars": complete.Command{
+				Flags: complete.Flags{
+					"--lib":           complete.PredictDirs("*"),
+					"--output-format": complete.PredictSet("text", "json"),
+					"--syntax":        complete.PredictSet("$", "go-template", "kind-template"),
+					"-x":              complete.PredictSet("$", "go-template", "kind-template"),
 				},
 				Args: complete.PredictFiles("*"),
 			},
@@ -71,7 +166,10 @@ func (c *Completion) Execute() (bool, error) {
 							"zsh":  complete.Command{},
 						},
 					},
+					"check":  complete.Command{},
+					"diff":   complete.Command{},
 					"render": complete.Command{},
+					"vars":   complete.Command{},
 				},
 			},
 		},
@@ -86,6 +184,8 @@ func (c *Completion) Execute() (bool, error) {
 	}
 	run.Sub["r"] = run.Sub["render"]
 	run.Sub["help"].Sub["r"] = run.Sub["help"].Sub["render"]
+	run.Sub["inspect"] = run.Sub["vars"]
+	run.Sub["help"].Sub["inspect"] = run.Sub["help"].Sub["vars"]
 	completion := complete.New(filepath.Base(bin), run)
 	if os.Getenv("COMP_LINE") != "" {
 		flag.Parse()
@@ -1,15 +1,250 @@
+// Package dotenv parses .env files (as understood by docker-compose / dotenv):
+//
+//	# comment
+//	export KEY=value # inline comment (unquoted values only)
+//	SINGLE='literal $VALUE, # and \n included'
+//	DOUBLE="escapes (\n, \t, \", \\, \$) are processed
+//	value can span multiple lines"
 package dotenv
 
-import "gopkg.in/ini.v1"
+import (
+	"fmt"
+	"strings"
+)
 
-func Parse(data []byte) (map[string]string, error) {
-	f, err := ini.Load(data)
-	if err != nil {
-		return nil, err
+// Error is a parse error.
+type Error struct {
+	Line int
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+type parser struct {
+	interpolate bool
+	lookup      func(key string) (string, bool)
+}
+
+// Option configures Parse.
+type Option func(p *parser)
+
+// Interpolate enables expansion of ${KEY}, $KEY, ${KEY:-default} and ${KEY-default} within unquoted and
+// double-quoted values ("-" is never a part of the referenced KEY). KEYs are resolved against variables defined earlier in the file and then
+// lookup (if not nil, e.g. os.LookupEnv). Unresolved KEYs expand to "" (unless default is given).
+func Interpolate(lookup func(key string) (string, bool)) Option {
+	return func(p *parser) {
+		p.interpolate = true
+		p.lookup = lookup
+	}
+}
+
+// Parse parses content of .env file.
+func Parse(data []byte, options ...Option) (map[string]string, error) {
+	p := &parser{}
+	for _, option := range options {
+		option(p)
+	}
+	src := strings.TrimPrefix(strings.Replace(string(data), "\r\n", "\n", -1), "\ufeff")
+	lines := strings.Split(src, "\n")
+	env := make(map[string]string)
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimLeft(lines[i], " \t")
+		lineNo := i + 1
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "export ") || strings.HasPrefix(line, "export\t") {
+			line = strings.TrimLeft(line[len("export"):], " \t")
+		}
+		eq := strings.IndexByte(line, '=')
+		if eq == -1 {
+			return nil, &Error{lineNo, fmt.Sprintf("expected <key>=<value>, instead got %q", strings.TrimSpace(line))}
+		}
+		key := strings.TrimRight(line[:eq], " \t")
+		if !isValidKey(key) {
+			return nil, &Error{lineNo, fmt.Sprintf("%q is not a valid key", key)}
+		}
+		rest := strings.TrimLeft(line[eq+1:], " \t")
+		var value string
+		var err error
+		if strings.HasPrefix(rest, `"`) || strings.HasPrefix(rest, `'`) {
+			quote := rest[0]
+			var buf strings.Builder
+			body := rest[1:]
+			for {
+				end := closingQuote(body, quote)
+				if end != -1 {
+					buf.WriteString(body[:end])
+					if after := strings.TrimSpace(body[end+1:]); after != "" && !strings.HasPrefix(after, "#") {
+						return nil, &Error{i + 1, fmt.Sprintf("unexpected %q after closing quote", after)}
+					}
+					break
+				}
+				buf.WriteString(body)
+				buf.WriteString("\n")
+				if i++; i == len(lines) {
+					return nil, &Error{lineNo, fmt.Sprintf("value of %s is missing closing %c", key, quote)}
+				}
+				body = lines[i]
+			}
+			value = buf.String()
+			if quote == '"' {
+				value, err = p.expand(value, true, env)
+			}
+		} else {
+			// "#" starts a comment only if preceded by whitespace (e.g. "KEY=a#b" is "a#b")
+			for j := 1; j < len(rest); j++ {
+				if rest[j] == '#' && (rest[j-1] == ' ' || rest[j-1] == '\t') {
+					rest = rest[:j]
+					break
+				}
+			}
+			if strings.HasPrefix(rest, "#") {
+				rest = ""
+			}
+			value, err = p.expand(strings.TrimRight(rest, " \t"), false, env)
+		}
+		if err != nil {
+			return nil, &Error{lineNo, err.Error()}
+		}
+		env[key] = value
+	}
+	return env, nil
+}
+
+func isValidKey(key string) bool {
+	if key == "" || (key[0] >= '0' && key[0] <= '9') {
+		return false
+	}
+	for _, c := range key {
+		if !(c == '_' || c == '.' || c == '-' || isAlphanumeric(c)) {
+			return false
+		}
+	}
+	return true
+}
+
+func isAlphanumeric(c rune) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// closingQuote returns index of the (unescaped, in case of '"') quote or -1 if there is none.
+func closingQuote(s string, quote byte) int {
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && quote == '"':
+			i++
+		case s[i] == quote:
+			return i
+		}
+	}
+	return -1
+}
+
+// expand processes escape sequences (if escapes is true) and (if enabled) ${KEY}/$KEY references.
+func (p *parser) expand(s string, escapes bool, env map[string]string) (string, error) {
+	var buf strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && escapes && i+1 < len(s):
+			i++
+			switch s[i] {
+			case 'n':
+				buf.WriteByte('\n')
+			case 'r':
+				buf.WriteByte('\r')
+			case 't':
+				buf.WriteByte('\t')
+			case '"', '\\', '$':
+				buf.WriteByte(s[i])
+			default:
+				buf.WriteByte('\\')
+				buf.WriteByte(s[i])
+			}
+		case c == '$' && p.interpolate && i+1 < len(s) && s[i+1] == '{':
+			end := closingBrace(s[i+2:])
+			if end == -1 {
+				return "", fmt.Errorf("%q is missing closing }", s[i:])
+			}
+			end += 2
+			expr := s[i+2 : i+end]
+			name, def, hasDef, colon := p.splitReference(expr)
+			if name == "" {
+				return "", fmt.Errorf("${%s} is not a valid reference", expr)
+			}
+			value, ok := p.resolve(name, env)
+			if hasDef && (!ok || (colon && value == "")) {
+				var err error
+				if value, err = p.expand(def, false, env); err != nil {
+					return "", err
+				}
+			}
+			buf.WriteString(value)
+			i += end
+		case c == '$' && p.interpolate && i+1 < len(s) && (s[i+1] == '_' || isAlphanumeric(rune(s[i+1]))) &&
+			!(s[i+1] >= '0' && s[i+1] <= '9'):
+			j := i + 1
+			for j < len(s) && (s[j] == '_' || isAlphanumeric(rune(s[j]))) {
+				j++
+			}
+			value, _ := p.resolve(s[i+1:j], env)
+			buf.WriteString(value)
+			i = j - 1
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	return buf.String(), nil
+}
+
+// closingBrace returns the index of } matching (already consumed) { (-1 if there is none).
+func closingBrace(s string) int {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		}
+	}
+	return -1
+}
+
+// splitReference splits expr (KEY, KEY-default or KEY:-default) into name and default ("" name means expr is not valid).
+// Same as in POSIX shell / docker-compose, "-" is always a modifier ("${A-B}" is A with default "B"), which means
+// keys containing "-" can be defined but not referenced.
+func (p *parser) splitReference(expr string) (name, def string, hasDef, colon bool) {
+	k := 0
+	for k < len(expr) && (expr[k] == '_' || expr[k] == '.' || isAlphanumeric(rune(expr[k]))) {
+		k++
+	}
+	if !isValidKey(expr[:k]) {
+		return "", "", false, false
+	}
+	switch {
+	case k == len(expr):
+		return expr, "", false, false
+	case strings.HasPrefix(expr[k:], ":-"):
+		return expr[:k], expr[k+2:], true, true
+	case expr[k] == '-':
+		return expr[:k], expr[k+1:], true, false
+	}
+	return "", "", false, false
+}
+
+func (p *parser) resolve(key string, env map[string]string) (string, bool) {
+	if value, ok := env[key]; ok {
+		return value, true
 	}
-	section, err := f.GetSection("")
-	if err != nil {
-		panic(err)
+	if p.lookup != nil {
+		return p.lookup(key)
 	}
-	return section.KeysHash(), nil
+	return "", false
 }
@@ -0,0 +1,92 @@
+package dotenv
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		in       string
+		expected map[string]string
+	}{
+		{"empty", "", map[string]string{}},
+		{"comments", "# comment\n  # indented comment\n\nA=1\n", map[string]string{"A": "1"}},
+		{"export", "export A=1\nexport\tB=2", map[string]string{"A": "1", "B": "2"}},
+		{"whitespace", "  A = 1  \nB=\nC=  ", map[string]string{"A": "1", "B": "", "C": ""}},
+		{"crlf", "A=1\r\nB=2\r\n", map[string]string{"A": "1", "B": "2"}},
+		{"inline comment", "A=1 # comment\nB=a#b\nC=#", map[string]string{"A": "1", "B": "a#b", "C": ""}},
+		{"single quoted", `A='a # b \n $B' # comment`, map[string]string{"A": `a # b \n $B`}},
+		{"double quoted", `A="a # b \n \t \" \\ \$ \d"`, map[string]string{"A": "a # b \n \t \" \\ $ \\d"}},
+		{"multi-line", "A=\"line 1\nline 2\"\nB='x\n\ny'\nC=3", map[string]string{"A": "line 1\nline 2", "B": "x\n\ny", "C": "3"}},
+		{"equals in value", "A=a=b", map[string]string{"A": "a=b"}},
+		{"no interpolation by default", "A=1\nB=${A}", map[string]string{"A": "1", "B": "${A}"}},
+	} {
+		actual, err := Parse([]byte(test.in))
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Fatalf("%s: actual: \n%#v != expected: \n%#v", test.name, actual, test.expected)
+		}
+	}
+}
+
+func TestParseInterpolate(t *testing.T) {
+	lookup := func(key string) (string, bool) {
+		if key == "HOME" {
+			return "/root", true
+		}
+		return "", false
+	}
+	actual, err := Parse([]byte("HOST=db\nURL=\"postgres://${HOST}:${PORT:-5432}/$NAME\"\nEMPTY=\n"+
+		"A=${EMPTY-x}|${EMPTY:-y}|${UNSET-z}\nB='$HOST'\nC=\"\\$HOST\"\nD=$HOME/$5\n"), Interpolate(lookup))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"HOST": "db", "URL": "postgres://db:5432/", "EMPTY": "", "A": "|y|z",
+		"B": "$HOST", "C": "$HOST", "D": "/root/$5"}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("actual: \n%#v != expected: \n%#v", actual, expected)
+	}
+}
+
+func TestParseInterpolateNested(t *testing.T) {
+	actual, err := Parse([]byte("A=a\nB=${X:-${A}}\nC=${X-${Y:-${A}-c}}\nMY-KEY=v\nMY=m\n"+
+		"D=${MY-KEY}|${MY-KEY-x}|${MY-KEY:-x}\nE=${A-B}|${X-B}|${X-}|${A-${X}}\n"), Interpolate(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"A": "a", "B": "a", "C": "a-c", "MY-KEY": "v", "MY": "m", "D": "m|m|m",
+		"E": "a|B||a"}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("actual: \n%#v != expected: \n%#v", actual, expected)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, test := range []struct {
+		in       string
+		expected string
+	}{
+		{"A=1\nB\n", `line 2: expected <key>=<value>, instead got "B"`},
+		{"A=1\n1A=2\n", `line 2: "1A" is not a valid key`},
+		{"A=1\nB=\"x\ny\n", `line 2: value of B is missing closing "`},
+		{"A=1\nB='x\ny' z\n", `line 3: unexpected "z" after closing quote`},
+	} {
+		_, err := Parse([]byte(test.in))
+		if err == nil || err.Error() != test.expected {
+			t.Fatalf("actual: \n%v != expected: \n%s", err, test.expected)
+		}
+	}
+	if _, err := Parse([]byte("A=${B"), Interpolate(nil)); err == nil {
+		t.Fatal("expected unterminated ${ to be reported")
+	}
+	if _, err := Parse([]byte("A=${B:-${C}"), Interpolate(nil)); err == nil {
+		t.Fatal("expected unterminated nested ${ to be reported")
+	}
+	if _, err := Parse([]byte("A=${B/c}"), Interpolate(nil)); err == nil {
+		t.Fatal("expected invalid reference to be reported")
+	}
+}
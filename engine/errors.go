@@ -0,0 +1,32 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+)
+
+// VarError is returned when variable isn't set (or has a value of unexpected type).
+type VarError struct {
+	Name string
+	// 1-based position of the variable within the template (0 if unknown)
+	Line, Column int
+	Message      string
+}
+
+func (e *VarError) Error() string {
+	if e.Line == 0 {
+		return e.Message
+	}
+	return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.Message)
+}
+
+// Errors is a list of errors collected while rendering (instead of failing on the first one).
+type Errors []error
+
+func (e Errors) Error() string {
+	s := make([]string, len(e))
+	for i, err := range e {
+		s[i] = err.Error()
+	}
+	return strings.Join(s, "\n")
+}
@@ -5,23 +5,62 @@ import (
 	"fmt"
 	"github.com/Masterminds/sprig"
 	"os"
+	"sort"
 	"text/template"
+	"text/template/parse"
 )
 
 type GoTemplate struct {
 	content []byte
 	name    string
+	lib     map[string][]byte
 }
 
-func NewGoTemplate(template []byte, name string) (Template, error) {
-	return GoTemplate{template, name}, nil
+type GoTemplateOption = func(*GoTemplate) error
+
+// GoTemplateLib makes partials (name -> content) available to the template
+// (via {{ template "<name>" . }} or {{ include "<name>" . }} (the latter returns a string and thus can be piped to
+// other functions (e.g. {{ include "probe.yml" . | indent 8 }})).
+// Any {{ define "..." }}s within partials are available too.
+func GoTemplateLib(partials map[string][]byte) GoTemplateOption {
+	return func(t *GoTemplate) error {
+		t.lib = partials
+		return nil
+	}
+}
+
+func NewGoTemplate(template []byte, name string, options ...GoTemplateOption) (Template, error) {
+	t := GoTemplate{content: template, name: name}
+	for _, option := range options {
+		if err := option(&t); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
 }
 
 func (t GoTemplate) Render(data map[string]interface{}) ([]byte, error) {
-	tmpl, err := template.New(t.name).Funcs(funcMap(data)).Option("missingkey=error").Parse(string(t.content))
+	tmpl := template.New(t.name)
+	f := funcMap(data)
+	f["include"] = func(name string, data interface{}) (string, error) {
+		var buf bytes.Buffer
+		err := tmpl.ExecuteTemplate(&buf, name, data)
+		return buf.String(), err
+	}
+	tmpl, err := tmpl.Funcs(f).Option("missingkey=error").Parse(string(t.content))
 	if err != nil {
 		return nil, err
 	}
+	var names []string
+	for name := range t.lib {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, err := tmpl.New(name).Parse(string(t.lib[name])); err != nil {
+			return nil, err
+		}
+	}
 	var buf bytes.Buffer
 	err = tmpl.Execute(&buf, data)
 	if err != nil {
@@ -48,7 +87,150 @@ func funcMap(data map[string]interface{}) template.FuncMap {
 		_, ok := m[key]
 		return ok
 	}
+	f["include"] = func(name string, data interface{}) (string, error) {
+		return "", fmt.Errorf(`template "%s" not found`, name)
+	}
 	delete(f, "env")
 	delete(f, "expandenv")
 	return f
 }
+
+func (t GoTemplate) Vars() ([]Var, error) {
+	tmpl, err := template.New(t.name).Funcs(funcMap(nil)).Parse(string(t.content))
+	if err != nil {
+		return nil, err
+	}
+	w := goVarWalker{content: string(t.content), vars: newVarIndex()}
+	for _, tt := range tmpl.Templates() {
+		if tt.Tree != nil && tt.Tree.Root != nil {
+			w.walk(tt.Tree.Root, true, nil)
+		}
+	}
+	return w.vars.slice(), nil
+}
+
+type goVarWalker struct {
+	content string
+	vars    *varIndex
+}
+
+func (w goVarWalker) add(name string, pos parse.Pos, optional bool) {
+	line, col := lineColumn(w.content, int(pos))
+	w.vars.add(name, Usage{Line: line, Column: col, Optional: optional})
+}
+
+// root is true if "." refers to the data map; guarded contains names checked with "isset".
+func (w goVarWalker) walk(node parse.Node, root bool, guarded map[string]bool) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, nn := range n.Nodes {
+			w.walk(nn, root, guarded)
+		}
+	case *parse.ActionNode:
+		w.pipe(n.Pipe, root, guarded)
+	case *parse.TemplateNode:
+		w.pipe(n.Pipe, root, guarded)
+	case *parse.IfNode:
+		w.pipe(n.Pipe, root, guarded)
+		g := make(map[string]bool, len(guarded))
+		for k := range guarded {
+			g[k] = true
+		}
+		for _, name := range issetNames(n.Pipe) {
+			g[name] = true
+		}
+		w.walk(n.List, root, g)
+		w.walk(n.ElseList, root, guarded)
+	case *parse.RangeNode:
+		w.pipe(n.Pipe, root, guarded)
+		w.walk(n.List, false, guarded)
+		w.walk(n.ElseList, root, guarded)
+	case *parse.WithNode:
+		w.pipe(n.Pipe, root, guarded)
+		w.walk(n.List, false, guarded)
+		w.walk(n.ElseList, root, guarded)
+	}
+}
+
+func (w goVarWalker) pipe(p *parse.PipeNode, root bool, guarded map[string]bool) {
+	if p == nil {
+		return
+	}
+	for _, cmd := range p.Cmds {
+		if name, node, ok := optionalLookup(cmd); ok {
+			w.add(name, node.Position(), true)
+		}
+		for _, arg := range cmd.Args {
+			w.arg(arg, root, guarded)
+		}
+	}
+}
+
+func (w goVarWalker) arg(node parse.Node, root bool, guarded map[string]bool) {
+	switch n := node.(type) {
+	case *parse.FieldNode:
+		if root {
+			w.add(n.Ident[0], n.Position(), guarded[n.Ident[0]])
+		}
+	case *parse.VariableNode:
+		if n.Ident[0] == "$" && len(n.Ident) > 1 {
+			w.add(n.Ident[1], n.Position(), guarded[n.Ident[1]])
+		}
+	case *parse.ChainNode:
+		w.arg(n.Node, root, guarded)
+	case *parse.PipeNode:
+		w.pipe(n, root, guarded)
+	}
+}
+
+// optionalLookup recognizes `isset "VAR"`, `get "VAR" default` and (deprecated) `def . "VAR"`.
+func optionalLookup(cmd *parse.CommandNode) (string, parse.Node, bool) {
+	if len(cmd.Args) < 2 {
+		return "", nil, false
+	}
+	ident, ok := cmd.Args[0].(*parse.IdentifierNode)
+	if !ok {
+		return "", nil, false
+	}
+	var arg parse.Node
+	switch ident.Ident {
+	case "isset", "get":
+		arg = cmd.Args[1]
+	case "def":
+		if len(cmd.Args) < 3 {
+			return "", nil, false
+		}
+		arg = cmd.Args[2]
+	default:
+		return "", nil, false
+	}
+	s, ok := arg.(*parse.StringNode)
+	if !ok {
+		return "", nil, false
+	}
+	return s.Text, s, true
+}
+
+func issetNames(p *parse.PipeNode) []string {
+	var r []string
+	if p == nil {
+		return r
+	}
+	for _, cmd := range p.Cmds {
+		for _, arg := range cmd.Args {
+			switch n := arg.(type) {
+			case *parse.PipeNode:
+				r = append(r, issetNames(n)...)
+			}
+		}
+		if name, _, ok := optionalLookup(cmd); ok {
+			if ident := cmd.Args[0].(*parse.IdentifierNode); ident.Ident != "get" {
+				r = append(r, name)
+			}
+		}
+	}
+	return r
+}
@@ -2,6 +2,7 @@ package engine
 
 import (
 	log "github.com/sirupsen/logrus"
+	"reflect"
 	"testing"
 )
 
@@ -90,3 +91,25 @@ xdefault valuex
 		t.Fatalf("actual: \n%s != expected: \n%s", actualDef, expectedDef)
 	}
 }
+
+func TestGoTemplateVars(t *testing.T) {
+	actual, err := Must(NewGoTemplate(
+		[]byte(`x{{ .VAR }}x
+x{{ if isset "OPT_VAR" }}{{ .OPT_VAR }}{{ end }}x
+x{{ get "DEF_VAR" "default value" }}x
+{{ range .ITEMS }}{{ .NOT_A_VAR }}{{ $.VAR | quote }}{{ end }}
+`), "template"),
+	).(VarLister).Vars()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Var{
+		{Name: "VAR", Required: true, Usages: []Usage{{Line: 1, Column: 5}, {Line: 4, Column: 39}}},
+		{Name: "OPT_VAR", Usages: []Usage{{Line: 2, Column: 14, Optional: true}, {Line: 2, Column: 29, Optional: true}}},
+		{Name: "DEF_VAR", Usages: []Usage{{Line: 3, Column: 9, Optional: true}}},
+		{Name: "ITEMS", Required: true, Usages: []Usage{{Line: 4, Column: 10}}},
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("actual: \n%#v != expected: \n%#v", actual, expected)
+	}
+}
@@ -0,0 +1,77 @@
+package engine
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Lookup resolves key (e.g. "db.host", "replicas[0]") against data.
+// Keys that are present in data as is (e.g. "db.host" coming from a config file) take precedence over paths.
+func Lookup(data map[string]interface{}, key string) (interface{}, bool) {
+	if v, ok := data[key]; ok {
+		return v, true
+	}
+	path, err := ParsePath(key)
+	if err != nil || len(path) < 2 {
+		return nil, false
+	}
+	var node interface{} = data
+	for _, p := range path {
+		var ok bool
+		switch n := node.(type) {
+		case map[string]interface{}:
+			node, ok = n[fmt.Sprintf("%v", p)]
+		case map[interface{}]interface{}:
+			if node, ok = n[p]; !ok {
+				node, ok = n[fmt.Sprintf("%v", p)]
+			}
+		case []interface{}:
+			if i, isIndex := p.(int); isIndex && i >= 0 && i < len(n) {
+				node, ok = n[i], true
+			}
+		}
+		if !ok {
+			return nil, false
+		}
+	}
+	return node, true
+}
+
+// PathRoot returns the top-level key of the path (e.g. "db" for "db.host").
+func PathRoot(key string) string {
+	if i := strings.IndexAny(key, ".["); i > 0 {
+		return key[:i]
+	}
+	return key
+}
+
+// ParsePath splits "a.b[0].c" into ["a", "b", 0, "c"].
+func ParsePath(key string) ([]interface{}, error) {
+	var r []interface{}
+	for _, segment := range strings.Split(key, ".") {
+		name := segment
+		if i := strings.Index(segment, "["); i != -1 {
+			name = segment[:i]
+		}
+		if name == "" && len(r) == 0 {
+			return nil, fmt.Errorf(`"%s" is not a valid path`, key)
+		}
+		if name != "" {
+			r = append(r, name)
+		}
+		for rest := segment[len(name):]; rest != ""; {
+			e := strings.Index(rest, "]")
+			if rest[0] != '[' || e == -1 {
+				return nil, fmt.Errorf(`"%s" is not a valid path`, key)
+			}
+			i, err := strconv.Atoi(rest[1:e])
+			if err != nil {
+				return nil, fmt.Errorf(`"%s" is not a valid path`, key)
+			}
+			r = append(r, i)
+			rest = rest[e+1:]
+		}
+	}
+	return r, nil
+}
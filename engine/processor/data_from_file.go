@@ -14,6 +14,7 @@ var kubetplDataFromEnvFile = "kubetpl/data-from-env-file"
 func ReplaceDataFromFileInPlace(
 	obj map[interface{}]interface{},
 	read func(file string) (string, []byte, error),
+	dotenvOptions ...dotenv.Option,
 ) (bool, error) {
 	if obj["kind"] != "ConfigMap" && obj["kind"] != "Secret" {
 		return false, nil
@@ -52,8 +53,11 @@ func ReplaceDataFromFileInPlace(
 		if err != nil {
 			return false, err
 		}
-		env, err := dotenv.Parse(value)
+		env, err := dotenv.Parse(value, dotenvOptions...)
 		if err != nil {
+			if de, ok := err.(*dotenv.Error); ok {
+				return false, fmt.Errorf("%s:%d: %s", e, de.Line, de.Msg)
+			}
 			return false, fmt.Errorf("%s: %s", e, err.Error())
 		}
 		for key, value := range env {
@@ -2,8 +2,11 @@ package processor
 
 import (
 	"crypto/sha256"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"regexp"
+	"sort"
 	"strings"
 
 	log "github.com/sirupsen/logrus"
@@ -17,6 +20,7 @@ const (
 	kindSecret                = "Secret"
 	kindPod                   = "Pod"
 	kindPodPreset             = "PodPreset"
+	kindPodTemplate           = "PodTemplate"
 	kindDaemonSet             = "DaemonSet"
 	kindDeployment            = "Deployment"
 	kindJob                   = "Job"
@@ -24,6 +28,8 @@ const (
 	kindReplicationController = "ReplicationController"
 	kindStatefulSet           = "StatefulSet"
 	kindCronJob               = "CronJob"
+	kindIngress               = "Ingress"
+	kindServiceAccount        = "ServiceAccount"
 )
 
 type frozenObjectRef struct {
@@ -32,36 +38,91 @@ type frozenObjectRef struct {
 	updatedName string
 }
 
-// kindConfigMap/kindSecret -> kind* -> []path
-var pathsToRewrite map[kind]map[kind][]string
+// groupKind identifies objects of a given kind regardless of the version (e.g. {"apps", "Deployment"} matches
+// both apps/v1beta2 and apps/v1 Deployment|s). group is "" in case of the core API group (apiVersion: v1).
+type groupKind struct {
+	group string
+	kind  kind
+}
+
+// anyGroup matches objects of a given kind in any API group.
+const anyGroup = "*"
+
+// API groups built-in kinds are matched in (objects of the same kind in any other group (e.g. a CRD named
+// "Deployment") are left untouched).
+var builtInKindGroups = map[kind][]string{
+	kindPod:                   {""},
+	kindPodTemplate:           {""},
+	kindReplicationController: {""},
+	kindServiceAccount:        {""},
+	kindDaemonSet:             {"apps", "extensions"},
+	kindDeployment:            {"apps", "extensions"},
+	kindReplicaSet:            {"apps", "extensions"},
+	kindStatefulSet:           {"apps"},
+	kindJob:                   {"batch"},
+	kindCronJob:               {"batch"},
+	kindPodPreset:             {"settings.k8s.io"},
+	kindIngress:               {"networking.k8s.io", "extensions"},
+}
+
+// kindConfigMap/kindSecret -> group/kind* -> []path
+var pathsToRewrite map[kind]map[groupKind][]string
+
+// group/kind* -> []path of references to Secrets that are usually managed outside of the template (image pull
+// secrets, TLS certificates (e.g. issued by cert-manager), ServiceAccount tokens, storage driver credentials, ...).
+// Unlike the rest, these are not required to be --freeze-ref'ed.
+var externalRefs map[groupKind][]string
 
 func init() {
 	configMap := make(map[kind][]string)
 	secret := make(map[kind][]string)
-	configMap[kindPod] = []string{
-		"spec.initContainers[*].env[*].valueFrom.configMapKeyRef.name",
-		"spec.initContainers[*].envFrom[*].configMapRef.name",
-		"spec.containers[*].env[*].valueFrom.configMapKeyRef.name",
-		"spec.containers[*].envFrom[*].configMapRef.name",
-		"spec.volumes[*].configMap.name",
-	}
-	secret[kindPod] = []string{
-		"spec.initContainers[*].env[*].valueFrom.secretKeyRef.name",
-		"spec.initContainers[*].envFrom[*].secretRef.name",
-		"spec.containers[*].env[*].valueFrom.secretKeyRef.name",
-		"spec.containers[*].envFrom[*].secretRef.name",
-		"spec.volumes[*].secret.secretName",
-	}
-	configMap[kindPodPreset] = []string{
-		"spec.env[*].valueFrom.configMapKeyRef.name",
-		"spec.envFrom[*].configMapRef.name",
-		"spec.volumes[*].configMap.name",
-	}
-	secret[kindPodPreset] = []string{
-		"spec.env[*].valueFrom.secretKeyRef.name",
-		"spec.envFrom[*].secretRef.name",
-		"spec.volumes[*].secret.secretName",
+	// env & envFrom (relative to the container)
+	containerConfigMap := []string{
+		"env[*].valueFrom.configMapKeyRef.name",
+		"envFrom[*].configMapRef.name",
+	}
+	containerSecret := []string{
+		"env[*].valueFrom.secretKeyRef.name",
+		"envFrom[*].secretRef.name",
 	}
+	// relative to the volume
+	volumeConfigMap := []string{
+		"configMap.name",
+		"projected.sources[*].configMap.name",
+	}
+	volumePluginSecret := []string{
+		"azureFile.secretName",
+		"cephfs.secretRef.name",
+		"cinder.secretRef.name",
+		"csi.nodePublishSecretRef.name",
+		"flexVolume.secretRef.name",
+		"iscsi.secretRef.name",
+		"rbd.secretRef.name",
+		"scaleIO.secretRef.name",
+		"storageos.secretRef.name",
+	}
+	volumeSecret := append([]string{
+		"secret.secretName",
+		"projected.sources[*].secret.name",
+	}, volumePluginSecret...)
+	external := make(map[kind][]string)
+	for _, containers := range []string{"spec.initContainers[*].", "spec.containers[*].", "spec.ephemeralContainers[*]."} {
+		configMap[kindPod] = append(configMap[kindPod], mapWithPrefix(containerConfigMap, containers)...)
+		secret[kindPod] = append(secret[kindPod], mapWithPrefix(containerSecret, containers)...)
+	}
+	configMap[kindPod] = append(configMap[kindPod], mapWithPrefix(volumeConfigMap, "spec.volumes[*].")...)
+	secret[kindPod] = append(secret[kindPod], mapWithPrefix(volumeSecret, "spec.volumes[*].")...)
+	secret[kindPod] = append(secret[kindPod], "spec.imagePullSecrets[*].name")
+	external[kindPod] = append(mapWithPrefix(volumePluginSecret, "spec.volumes[*]."), "spec.imagePullSecrets[*].name")
+	configMap[kindPodPreset] = append(
+		mapWithPrefix(containerConfigMap, "spec."),
+		mapWithPrefix(volumeConfigMap, "spec.volumes[*].")...,
+	)
+	secret[kindPodPreset] = append(
+		mapWithPrefix(containerSecret, "spec."),
+		mapWithPrefix(volumeSecret, "spec.volumes[*].")...,
+	)
+	external[kindPodPreset] = mapWithPrefix(volumePluginSecret, "spec.volumes[*].")
 	for _, kind := range []string{
 		kindDaemonSet,
 		kindDeployment,
@@ -72,13 +133,199 @@ func init() {
 	} {
 		configMap[kind] = mapWithPrefix(configMap[kindPod], "spec.template.")
 		secret[kind] = mapWithPrefix(secret[kindPod], "spec.template.")
+		external[kind] = mapWithPrefix(external[kindPod], "spec.template.")
 	}
+	configMap[kindPodTemplate] = mapWithPrefix(configMap[kindPod], "template.")
+	secret[kindPodTemplate] = mapWithPrefix(secret[kindPod], "template.")
+	external[kindPodTemplate] = mapWithPrefix(external[kindPod], "template.")
 	configMap[kindCronJob] = mapWithPrefix(configMap[kindPod], "spec.jobTemplate.spec.template.")
 	secret[kindCronJob] = mapWithPrefix(secret[kindPod], "spec.jobTemplate.spec.template.")
-	pathsToRewrite = map[kind]map[kind][]string{
-		kindConfigMap: configMap,
-		kindSecret:    secret,
+	external[kindCronJob] = mapWithPrefix(external[kindPod], "spec.jobTemplate.spec.template.")
+	secret[kindIngress] = []string{
+		"spec.tls[*].secretName",
+	}
+	external[kindIngress] = secret[kindIngress]
+	secret[kindServiceAccount] = []string{
+		"secrets[*].name",
+		"imagePullSecrets[*].name",
+	}
+	external[kindServiceAccount] = secret[kindServiceAccount]
+	pathsToRewrite = map[kind]map[groupKind][]string{
+		kindConfigMap: withBuiltInGroups(configMap),
+		kindSecret:    withBuiltInGroups(secret),
+	}
+	externalRefs = withBuiltInGroups(external)
+}
+
+func withBuiltInGroups(rules map[kind][]string) map[groupKind][]string {
+	r := make(map[groupKind][]string)
+	for kind, paths := range rules {
+		for _, group := range builtInKindGroups[kind] {
+			r[groupKind{group, kind}] = paths
+		}
+	}
+	return r
+}
+
+// objectGroupKind returns group/kind of the object (group is anyGroup if apiVersion is missing).
+func objectGroupKind(obj map[interface{}]interface{}) groupKind {
+	kind, _ := obj["kind"].(string)
+	apiVersion, ok := obj["apiVersion"].(string)
+	if !ok || apiVersion == "" {
+		return groupKind{anyGroup, kind}
+	}
+	if i := strings.LastIndex(apiVersion, "/"); i != -1 {
+		return groupKind{apiVersion[:i], kind}
+	}
+	return groupKind{"", kind}
+}
+
+// isConfigMapOrSecret returns true if obj is a (core) ConfigMap/Secret.
+func isConfigMapOrSecret(obj map[interface{}]interface{}) bool {
+	gk := objectGroupKind(obj)
+	return (gk.kind == kindConfigMap || gk.kind == kindSecret) && (gk.group == "" || gk.group == anyGroup)
+}
+
+// isExternalRef returns true if path (within obj) is one of the (built-in) externalRefs.
+func isExternalRef(obj map[interface{}]interface{}, path string) bool {
+	for _, p := range matchPaths(externalRefs, objectGroupKind(obj)) {
+		if p == path {
+			return true
+		}
+	}
+	return false
+}
+
+// FreezePath is a path (e.g. "spec.template.spec.volumes[*].configMap.name", "[*]" standing for "each item of
+// the list") to the name of the ConfigMap/Secret (RefKind) within objects of a given Kind (and API Group
+// (e.g. "argoproj.io"), if set (otherwise objects of a given Kind are matched regardless of the group)).
+type FreezePath struct {
+	RefKind string
+	Group   string
+	Kind    string
+	Path    string
+}
+
+// splitKind splits "<Kind>[.<group>]" (e.g. "Rollout.argoproj.io").
+func splitKind(s string) (string, string) {
+	if i := strings.Index(s, "."); i != -1 {
+		return s[i+1:], s[:i]
+	}
+	return "", s
+}
+
+func (p FreezePath) qualifiedKind() string {
+	if p.Group == "" {
+		return p.Kind
+	}
+	return p.Kind + "." + p.Group
+}
+
+var freezePathRegexp = regexp.MustCompile(`^[^.\[\]]+(\[\*\])?(\.[^.\[\]]+(\[\*\])?)*$`)
+
+// ParseFreezePath parses "<Kind>[.<group>]:<path>[:<ConfigMap|Secret>]" (e.g.
+// "Rollout.argoproj.io:spec.template.spec.containers[*].envFrom[*].configMapRef.name"). If ConfigMap/Secret is
+// omitted it's derived from the path (which, in this case, must mention either "configMap" or "secret"
+// (case-insensitive)).
+func ParseFreezePath(s string) (FreezePath, error) {
+	split := strings.Split(s, ":")
+	if len(split) < 2 || len(split) > 3 || split[0] == "" {
+		return FreezePath{}, fmt.Errorf(`"%s" is not a valid freeze path (expected <kind>[.<group>]:<path>[:<ConfigMap|Secret>])`, s)
+	}
+	group, kind := splitKind(split[0])
+	p := FreezePath{Group: group, Kind: kind, Path: split[1]}
+	if len(split) == 3 {
+		p.RefKind = split[2]
+	} else {
+		lc := strings.ToLower(p.Path)
+		hasConfigMap, hasSecret := strings.Contains(lc, "configmap"), strings.Contains(lc, "secret")
+		switch {
+		case hasConfigMap && !hasSecret:
+			p.RefKind = kindConfigMap
+		case hasSecret && !hasConfigMap:
+			p.RefKind = kindSecret
+		default:
+			return FreezePath{}, fmt.Errorf(`"%s": unable to tell whether path refers to ConfigMap or Secret `+
+				"(please use <kind>:<path>:<ConfigMap|Secret>)", s)
+		}
+	}
+	return p, p.validate()
+}
+
+func (p FreezePath) validate() error {
+	if p.RefKind != kindConfigMap && p.RefKind != kindSecret {
+		return fmt.Errorf(`"%s:%s:%s": expected either ConfigMap or Secret, instead got "%s"`,
+			p.qualifiedKind(), p.Path, p.RefKind, p.RefKind)
+	}
+	if p.Kind == "" || p.Kind == kindConfigMap || p.Kind == kindSecret {
+		return fmt.Errorf(`"%s:%s": kind must be set (and cannot be ConfigMap/Secret)`, p.qualifiedKind(), p.Path)
+	}
+	if !freezePathRegexp.MatchString(p.Path) || strings.HasSuffix(p.Path, "[*]") {
+		return fmt.Errorf(`"%s:%s": "%s" is not a valid path (expected something like `+
+			`"spec.template.spec.volumes[*].configMap.name")`, p.qualifiedKind(), p.Path, p.Path)
+	}
+	return nil
+}
+
+// ParseFreezePaths parses YAML/JSON document of the form
+//
+//	ConfigMap:
+//	  <Kind>[.<group>]:
+//	  - <path>
+//	Secret:
+//	  <Kind>[.<group>]:
+//	  - <path>
+func ParseFreezePaths(data []byte) ([]FreezePath, error) {
+	var m map[string]map[string][]string
+	if err := yaml.UnmarshalStrict(data, &m); err != nil {
+		return nil, err
+	}
+	var r []FreezePath
+	for _, refKind := range []string{kindConfigMap, kindSecret} {
+		kinds := m[refKind]
+		delete(m, refKind)
+		var keys []string
+		for kind := range kinds {
+			keys = append(keys, kind)
+		}
+		sort.Strings(keys)
+		for _, kind := range keys {
+			for _, path := range kinds[kind] {
+				group, kind := splitKind(kind)
+				p := FreezePath{RefKind: refKind, Group: group, Kind: kind, Path: path}
+				if err := p.validate(); err != nil {
+					return nil, err
+				}
+				r = append(r, p)
+			}
+		}
+	}
+	for key := range m {
+		return nil, fmt.Errorf(`unexpected "%s" (expected ConfigMap and/or Secret)`, key)
 	}
+	return r, nil
+}
+
+// withPaths returns pathsToRewrite extended with paths.
+func withPaths(paths []FreezePath) map[kind]map[groupKind][]string {
+	if len(paths) == 0 {
+		return pathsToRewrite
+	}
+	r := make(map[kind]map[groupKind][]string, len(pathsToRewrite))
+	for refKind, rules := range pathsToRewrite {
+		r[refKind] = make(map[groupKind][]string, len(rules))
+		for gk, p := range rules {
+			r[refKind][gk] = p
+		}
+	}
+	for _, p := range paths {
+		gk := groupKind{p.Group, p.Kind}
+		if gk.group == "" {
+			gk.group = anyGroup
+		}
+		r[p.RefKind][gk] = append(append([]string{}, r[p.RefKind][gk]...), p.Path)
+	}
+	return r
 }
 
 func mapWithPrefix(slice []string, prefix string) []string {
@@ -89,13 +336,84 @@ func mapWithPrefix(slice []string, prefix string) []string {
 	return s
 }
 
+// Ways to compute hash of the ConfigMap/Secret (see FreezeRequest.Hash).
+const (
+	// hash of the whole object (default)
+	FreezeHashObject = "object"
+	// hash of data/binaryData/stringData only (changes to labels, annotations, etc don't change the name)
+	FreezeHashData = "data"
+	// hash kustomize's configMapGenerator/secretGenerator would append (length is fixed at 10)
+	FreezeHashKustomize = "kustomize"
+)
+
+const (
+	DefaultFreezeHashLength   = 7
+	DefaultFreezeNameTemplate = "{{name}}-{{hash}}"
+	// (kustomize hash is always 10 characters long)
+	kustomizeHashLength = 10
+	// max length of the DNS subdomain name (RFC 1123)
+	maxNameLength = 253
+)
+
 type FreezeRequest struct {
 	Docs    []map[interface{}]interface{}
 	Refs    []map[interface{}]interface{}
 	Include []string
+	// Paths in addition to the built-in ones.
+	Paths []FreezePath
+	// FreezeHashObject (default), FreezeHashData or FreezeHashKustomize.
+	Hash string
+	// Number of hex characters of the hash to keep (DefaultFreezeHashLength by default).
+	HashLength int
+	// Name of the frozen object, DefaultFreezeNameTemplate by default ({{name}} - original name, {{hash}} - hash).
+	NameTemplate string
+}
+
+type freezeNaming struct {
+	hash         string
+	hashLength   int
+	nameTemplate string
+}
+
+func (r FreezeRequest) naming() (freezeNaming, error) {
+	n := freezeNaming{hash: r.Hash, hashLength: r.HashLength, nameTemplate: r.NameTemplate}
+	switch n.hash {
+	case "":
+		n.hash = FreezeHashObject
+	case FreezeHashObject, FreezeHashData, FreezeHashKustomize:
+	default:
+		return n, fmt.Errorf(`Unknown freeze hash "%s" (expected object, data or kustomize)`, n.hash)
+	}
+	if n.hash == FreezeHashKustomize {
+		if n.hashLength != 0 && n.hashLength != kustomizeHashLength {
+			return n, fmt.Errorf("Freeze hash length cannot be changed when kustomize hash is used (it's always %d)",
+				kustomizeHashLength)
+		}
+		n.hashLength = kustomizeHashLength
+	}
+	if n.hashLength == 0 {
+		n.hashLength = DefaultFreezeHashLength
+	}
+	if n.hashLength < 1 || n.hashLength > sha256.Size*2 {
+		return n, fmt.Errorf("Freeze hash length must be between 1 and %d (got %d)", sha256.Size*2, n.hashLength)
+	}
+	if n.nameTemplate == "" {
+		n.nameTemplate = DefaultFreezeNameTemplate
+	}
+	if !strings.Contains(n.nameTemplate, "{{hash}}") ||
+		strings.Contains(strings.NewReplacer("{{name}}", "", "{{hash}}", "").Replace(n.nameTemplate), "{{") {
+		return n, fmt.Errorf(`"%s" is not a valid freeze name template `+
+			"(expected something like %s ({{hash}} is required, {{name}} is optional))", n.nameTemplate, DefaultFreezeNameTemplate)
+	}
+	return n, nil
 }
 
 func FreezeInPlace(r FreezeRequest) error {
+	naming, err := r.naming()
+	if err != nil {
+		return err
+	}
+	rules := withPaths(r.Paths)
 	var refs []frozenObjectRef
 	var includeIndex map[string]bool
 	if r.Include != nil {
@@ -111,13 +429,13 @@ func FreezeInPlace(r FreezeRequest) error {
 		kind := obj["kind"].(string)
 		meta := obj["metadata"].(map[interface{}]interface{})
 		name := meta["name"].(string)
-		if kind != kindConfigMap && kind != kindSecret {
+		if !isConfigMapOrSecret(obj) {
 			continue
 		}
 		if includeIndex != nil && !includeIndex[kind+"/"+name] {
 			continue
 		}
-		ref, err := freeze(obj)
+		ref, err := freeze(obj, naming)
 		if err != nil {
 			return err
 		}
@@ -151,12 +469,12 @@ nextAssertion:
 	// making sure no ConfigMap/Secret evades freezing (subject to FreezeRequest.Include)
 	for _, obj := range r.Docs {
 		kind := obj["kind"].(string)
-		if kind != kindConfigMap && kind != kindSecret {
+		if !isConfigMapOrSecret(obj) {
 			meta := obj["metadata"].(map[interface{}]interface{})
 			name := meta["name"].(string)
 			for _, ref := range refs {
-				if err := traverseRefs(obj, ref, func(node map[interface{}]interface{}, key string, path string) error {
-					if v, ok := node[key].(string); ok {
+				if err := traverseRefs(obj, ref, rules, func(node map[interface{}]interface{}, key string, path string) error {
+					if v, ok := node[key].(string); ok && !isExternalRef(obj, path) {
 						key := ref.kind + "/" + v
 						if !refIndex[key] && (includeIndex == nil || includeIndex[key]) {
 							return fmt.Errorf(`Stumbled upon unknown %s reference (in %s/%s).`+
@@ -177,7 +495,7 @@ nextAssertion:
 		kind := obj["kind"].(string)
 		meta := obj["metadata"].(map[interface{}]interface{})
 		name := meta["name"].(string)
-		if kind == kindConfigMap || kind == kindSecret {
+		if isConfigMapOrSecret(obj) {
 			for _, ref := range refs {
 				if kind == ref.kind && name == ref.name {
 					meta["name"] = ref.updatedName
@@ -186,7 +504,7 @@ nextAssertion:
 			}
 		} else {
 			for _, ref := range refs {
-				traverseRefs(obj, ref, func(node map[interface{}]interface{}, key string, path string) error {
+				traverseRefs(obj, ref, rules, func(node map[interface{}]interface{}, key string, path string) error {
 					if node[key] == ref.name {
 						log.Debugf(`freeze: rewriting %s to %s (%s in %s/%s)`, ref.name, ref.updatedName, path, kind, name)
 						node[key] = ref.updatedName
@@ -215,38 +533,106 @@ func validate(obj map[interface{}]interface{}) error {
 	return nil
 }
 
-func freeze(obj map[interface{}]interface{}) (frozenObjectRef, error) {
+func freeze(obj map[interface{}]interface{}, naming freezeNaming) (frozenObjectRef, error) {
 	kind := obj["kind"].(string)
 	meta := obj["metadata"].(map[interface{}]interface{})
 	name := meta["name"].(string)
-	snapshot, err := yaml.Marshal(obj)
+	var snapshot []byte
+	var err error
+	switch naming.hash {
+	case FreezeHashData:
+		data := make(map[interface{}]interface{})
+		for _, key := range []string{"data", "binaryData", "stringData"} {
+			if v, ok := obj[key]; ok {
+				data[key] = v
+			}
+		}
+		snapshot, err = yaml.Marshal(data)
+	case FreezeHashKustomize:
+		snapshot, err = kustomizeSnapshot(obj, kind, name)
+	default:
+		snapshot, err = yaml.Marshal(obj)
+	}
 	if err != nil {
 		return frozenObjectRef{}, err
 	}
 	sum := fmt.Sprintf("%x", sha256.Sum256(snapshot))
-	updatedName := fmt.Sprintf("%s-%s", name, sum[:7])
+	if naming.hash == FreezeHashKustomize {
+		sum = kustomizeEncode(sum)
+	}
+	updatedName := strings.NewReplacer("{{name}}", name, "{{hash}}", sum[:naming.hashLength]).
+		Replace(naming.nameTemplate)
+	if len(updatedName) > maxNameLength {
+		return frozenObjectRef{}, fmt.Errorf(`%s/%s: frozen name "%s" is longer than %d characters`,
+			kind, name, updatedName, maxNameLength)
+	}
 	return frozenObjectRef{kind: kind, name: name, updatedName: updatedName}, nil
 }
 
+// kustomizeSnapshot returns ConfigMap/Secret encoded the same way kustomize does it before hashing
+// (JSON (keys sorted) of kind, name, data, binaryData (ConfigMap, if not empty) & type (Secret)).
+// Just like in kustomize, Secret's stringData is ignored.
+func kustomizeSnapshot(obj map[interface{}]interface{}, kind string, name string) ([]byte, error) {
+	m := map[string]interface{}{"kind": kind, "name": name, "data": stringMap(obj["data"])}
+	if kind == kindSecret {
+		typ, _ := obj["type"].(string)
+		m["type"] = typ
+	} else if binaryData := stringMap(obj["binaryData"]); len(binaryData) > 0 {
+		m["binaryData"] = binaryData
+	}
+	return json.Marshal(m)
+}
+
+func stringMap(v interface{}) map[string]string {
+	r := make(map[string]string)
+	m, _ := v.(map[interface{}]interface{})
+	for key, value := range m {
+		r[fmt.Sprintf("%v", key)] = fmt.Sprintf("%v", value)
+	}
+	return r
+}
+
+// kustomizeEncode replaces some of the characters of the hex-encoded hash (to avoid "bad words" appearing in
+// the names) the way kustomize does.
+func kustomizeEncode(hex string) string {
+	enc := []rune(hex[:kustomizeHashLength])
+	for i := range enc {
+		switch enc[i] {
+		case '0':
+			enc[i] = 'g'
+		case '1':
+			enc[i] = 'h'
+		case '3':
+			enc[i] = 'k'
+		case 'a':
+			enc[i] = 'm'
+		case 'e':
+			enc[i] = 't'
+		}
+	}
+	return string(enc)
+}
+
 func traverseRefs(
 	obj map[interface{}]interface{},
 	ref frozenObjectRef,
+	pathsToRewrite map[kind]map[groupKind][]string,
 	cb func(node map[interface{}]interface{}, key string, path string) error,
 ) error {
 	rules, ok := pathsToRewrite[ref.kind]
 	if !ok {
 		return nil
 	}
-	kind := obj["kind"].(string)
-	paths, ok := rules[kind]
-	if !ok {
-		return nil
-	}
-	for _, path := range paths {
+	for _, path := range matchPaths(rules, objectGroupKind(obj)) {
 		d := strings.LastIndex(path, ".")
 		last := path[d+1:]
 		rr := []interface{}{obj}
-		for _, p := range strings.Split(path[0:d], "[*].") {
+		var segments []string
+		if d != -1 {
+			// (lists are expanded regardless of whether "[*]" is at the end of the segment or not)
+			segments = strings.Split(strings.TrimSuffix(path[0:d], "[*]"), "[*].")
+		}
+		for _, p := range segments {
 			var rn []interface{}
 			for _, r := range rr {
 				m, ok := r.(map[interface{}]interface{})
@@ -275,6 +661,33 @@ func traverseRefs(
 	return nil
 }
 
+// matchPaths returns paths applicable to objects of a given group/kind (if group is unknown (anyGroup) - paths
+// of all the groups kind is known in are returned).
+func matchPaths(rules map[groupKind][]string, gk groupKind) []string {
+	var groups []string
+	if gk.group == anyGroup {
+		for k := range rules {
+			if k.kind == gk.kind && k.group != anyGroup {
+				groups = append(groups, k.group)
+			}
+		}
+		sort.Strings(groups)
+	} else {
+		groups = []string{gk.group}
+	}
+	var paths []string
+	seen := make(map[string]bool)
+	for _, group := range append(groups, anyGroup) {
+		for _, path := range rules[groupKind{group, gk.kind}] {
+			if !seen[path] {
+				seen[path] = true
+				paths = append(paths, path)
+			}
+		}
+	}
+	return paths
+}
+
 func get(m map[interface{}]interface{}, path []string) interface{} {
 	r := m
 	var ok bool
@@ -0,0 +1,37 @@
+package processor
+
+import "testing"
+
+// Expected hashes are the ones kustomize produces for the same ConfigMap/Secret|s
+// (see TestConfigMapHash/TestSecretHash in sigs.k8s.io/kustomize/api/hasher/hasher_test.go).
+func TestFreezeKustomizeHash(t *testing.T) {
+	for _, test := range []struct {
+		obj      map[interface{}]interface{}
+		expected string
+	}{
+		{map[interface{}]interface{}{"kind": "ConfigMap"}, "42745tchd9"},
+		{map[interface{}]interface{}{"kind": "ConfigMap", "data": map[interface{}]interface{}{"one": ""}}, "9g67k2htb6"},
+		{map[interface{}]interface{}{"kind": "ConfigMap",
+			"data": map[interface{}]interface{}{"two": "2", "one": "", "three": "3"}}, "f5h7t85m9b"},
+		{map[interface{}]interface{}{"kind": "ConfigMap", "data": map[interface{}]interface{}{"one": ""},
+			"binaryData": map[interface{}]interface{}{"two": ""}}, "698h7c7t9m"},
+		{map[interface{}]interface{}{"kind": "Secret", "type": "my-type"}, "t75bgf6ctb"},
+		{map[interface{}]interface{}{"kind": "Secret", "type": "my-type",
+			"data": map[interface{}]interface{}{"one": ""}}, "74bd68bm66"},
+		{map[interface{}]interface{}{"kind": "Secret", "type": "my-type",
+			"data": map[interface{}]interface{}{"two": "Mg==", "one": "", "three": "Mw=="}}, "dgcb6h9tmk"},
+	} {
+		test.obj["metadata"] = map[interface{}]interface{}{"name": ""}
+		ref, err := freeze(test.obj, freezeNaming{
+			hash:         FreezeHashKustomize,
+			hashLength:   kustomizeHashLength,
+			nameTemplate: "{{hash}}",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ref.updatedName != test.expected {
+			t.Fatalf("%v: actual: \n%s != expected: \n%s", test.obj, ref.updatedName, test.expected)
+		}
+	}
+}
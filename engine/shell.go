@@ -1,15 +1,21 @@
 package engine
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	yamlext "github.com/shyiko/kubetpl/yaml"
 	"gopkg.in/yaml.v2"
-	"runtime"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
 )
 
 type ShellTemplate struct {
 	content     []byte
 	ignoreUnset bool
+	nodeAware   bool
 }
 
 type ShellTemplateOption = func(*ShellTemplate) error
@@ -22,7 +28,7 @@ func ShellTemplateIgnoreUnset() ShellTemplateOption {
 }
 
 func NewShellTemplate(template []byte, options ...ShellTemplateOption) (Template, error) {
-	tpl := ShellTemplate{template, false}
+	tpl := ShellTemplate{content: template}
 	for _, option := range options {
 		if err := option(&tpl); err != nil {
 			return nil, err
@@ -31,15 +37,7 @@ func NewShellTemplate(template []byte, options ...ShellTemplateOption) (Template
 	return tpl, nil
 }
 
-func (t ShellTemplate) Render(data map[string]interface{}) (res []byte, err error) {
-	defer func() {
-		if r := recover(); r != nil {
-			if _, ok := r.(runtime.Error); ok {
-				panic(r)
-			}
-			err = r.(error)
-		}
-	}()
+func (t ShellTemplate) Render(data map[string]interface{}) ([]byte, error) {
 	// ensure that input is a valid yaml even if expansion is done over the whole string
 	// and not individual nodes (for now)
 	for _, chunk := range yamlext.Chunk(t.content) {
@@ -47,6 +45,9 @@ func (t ShellTemplate) Render(data map[string]interface{}) (res []byte, err erro
 			return nil, err
 		}
 	}
+	if t.nodeAware {
+		return t.renderNodes(data)
+	}
 	r, err := envsubst(string(t.content), data, t.ignoreUnset)
 	if err != nil {
 		return nil, err
@@ -54,32 +55,193 @@ func (t ShellTemplate) Render(data map[string]interface{}) (res []byte, err erro
 	return []byte(r), nil
 }
 
-func envsubst(value string, env map[string]interface{}, ignoreUnset bool) (res string, err error) {
-	defer func() {
-		if r := recover(); r != nil {
-			if _, ok := r.(runtime.Error); ok {
-				panic(r)
-			}
-			err = r.(error)
+func envsubst(value string, env map[string]interface{}, ignoreUnset bool) (string, error) {
+	var errs Errors
+	assigned := make(map[string]interface{}) // ${VAR:=default}
+	lookup := func(name string) (interface{}, bool) {
+		if v, ok := assigned[name]; ok {
+			return v, true
 		}
-	}()
-	res = expandWithLineColumnInfo(value, func(key string, line int, col int) (string, bool) {
+		v, ok := Lookup(env, name)
+		return v, ok && v != nil
+	}
+	lines := strings.Split(value, "\n")
+	var resolve func(key string, line int, col int, standalone bool) (string, bool)
+	resolve = func(key string, line int, col int, standalone bool) (string, bool) {
 		if key == "$" || key == "" {
 			return "$", true
 		}
-		value, ok := env[key]
-		if !ok || value == nil {
+		e := parseShellExpr(key)
+		fail := func(format string, a ...interface{}) (string, bool) {
+			errs = append(errs, &VarError{Name: e.name, Line: line, Column: col, Message: fmt.Sprintf(format, a...)})
+			return "", true
+		}
+		word := func() string {
+			return expandWithLineColumnInfo(e.word, func(key string, _ int, _ int) (string, bool) {
+				return resolve(key, line, col, false)
+			})
+		}
+		value, set := lookup(e.name)
+		unset := !set
+		if set && strings.HasPrefix(e.op, ":") && e.op != ":" && yamlext.IsBasicType(value) {
+			unset = fmt.Sprintf("%v", value) == "" // ":" in ":-", ":=", ":?" and ":+" means "unset or empty"
+		}
+		switch e.op {
+		case "-", ":-":
+			if unset {
+				return word(), true
+			}
+		case "=", ":=":
+			if unset {
+				w := word()
+				assigned[e.name] = w
+				return w, true
+			}
+		case "?", ":?":
+			if unset {
+				if msg := word(); msg != "" {
+					return fail("\"%s\" isn't set (%s)", e.name, msg)
+				}
+				return fail("\"%s\" isn't set", e.name)
+			}
+		case "+", ":+":
+			if unset {
+				return "", true
+			}
+			return word(), true
+		}
+		if !set {
 			if ignoreUnset {
 				return "", false
 			}
-			panic(fmt.Errorf("%d:%d: \"%s\" isn't set", line, col, key))
+			return fail("\"%s\" isn't set", e.name)
 		}
 		if !yamlext.IsBasicType(value) {
-			panic(fmt.Errorf("%d:%d: \"%s\" must be either a string, number or a boolean", line, col, key))
+			if standalone && e.op == "" {
+				// splice map/list as a (flow style) YAML subtree
+				var buf bytes.Buffer
+				enc := json.NewEncoder(&buf)
+				enc.SetEscapeHTML(false)
+				if err := enc.Encode(yamlext.JSONCompatible(value)); err == nil {
+					return strings.TrimSuffix(buf.String(), "\n"), true
+				}
+			}
+			return fail("\"%s\" must be either a string, number or a boolean"+
+				" (maps/lists can only be used as a standalone value (e.g. \"key: ${%s}\"))", e.name, e.name)
 		}
-		return fmt.Sprintf("%v", value), true
+		v := fmt.Sprintf("%v", value)
+		switch e.op {
+		case "#":
+			return strconv.Itoa(utf8.RuneCountInString(v)), true
+		case ":":
+			sub, err := substring(v, e.word)
+			if err != nil {
+				return fail("\"%s\": %s", key, err.Error())
+			}
+			return sub, true
+		}
+		return v, true
+	}
+	res := expandWithLineColumnInfo(value, func(key string, line int, col int) (string, bool) {
+		return resolve(key, line, col, isStandaloneValue(lines[line-1], col-1, key))
 	})
-	return
+	if errs != nil {
+		return "", errs
+	}
+	return res, nil
+}
+
+// shellExpr is a parsed ${...} expression, e.g. ${VAR:-default} = {name: "VAR", op: ":-", word: "default"}.
+type shellExpr struct {
+	name string
+	// "" (${VAR}), "-"/":-" (default), "="/":=" (assign default), "?"/":?" (error if unset), "+"/":+" (alternate),
+	// ":" (${VAR:offset[:length]}) or "#" (${#VAR})
+	op   string
+	word string
+}
+
+func parseShellExpr(key string) shellExpr {
+	if len(key) > 1 && key[0] == '#' {
+		return shellExpr{name: key[1:], op: "#"}
+	}
+	if len(key) == 1 {
+		return shellExpr{name: key}
+	}
+	i := 0
+	for i < len(key) && (isAlphaNum(key[i]) || key[i] == '.' || key[i] == '[' || key[i] == ']') {
+		i++
+	}
+	name, rest := key[:i], key[i:]
+	switch {
+	case name == "" || rest == "":
+		return shellExpr{name: key}
+	case len(rest) > 1 && rest[0] == ':' && strings.IndexByte("-=?+", rest[1]) != -1:
+		return shellExpr{name: name, op: rest[:2], word: rest[2:]}
+	case rest[0] == ':':
+		return shellExpr{name: name, op: ":", word: rest[1:]}
+	case strings.IndexByte("-=?+", rest[0]) != -1:
+		return shellExpr{name: name, op: rest[:1], word: rest[1:]}
+	}
+	return shellExpr{name: key}
+}
+
+// substring implements ${VAR:offset} / ${VAR:offset:length} (negative offset/length count from the end).
+func substring(s string, spec string) (string, error) {
+	parseInt := func(v string) (int, error) {
+		v = strings.TrimSpace(v)
+		if strings.HasPrefix(v, "(") && strings.HasSuffix(v, ")") {
+			v = strings.TrimSpace(v[1 : len(v)-1])
+		}
+		return strconv.Atoi(v)
+	}
+	split := strings.SplitN(spec, ":", 2)
+	r := []rune(s)
+	offset, err := parseInt(split[0])
+	if err != nil {
+		return "", fmt.Errorf("bad substitution (offset must be an integer)")
+	}
+	if offset < 0 {
+		offset += len(r)
+	}
+	if offset < 0 || offset > len(r) {
+		return "", nil
+	}
+	end := len(r)
+	if len(split) == 2 {
+		length, err := parseInt(split[1])
+		if err != nil {
+			return "", fmt.Errorf("bad substitution (length must be an integer)")
+		}
+		if length < 0 {
+			end += length
+		} else if offset+length < end {
+			end = offset + length
+		}
+		if end < offset {
+			return "", fmt.Errorf("bad substitution (substring expression < 0)")
+		}
+	}
+	return string(r[offset:end]), nil
+}
+
+var (
+	standaloneValuePrefix = regexp.MustCompile(`^\s*(- +)*([^\s#'"{\[,-][^#]*?:\s+(- +)*)?$`)
+	standaloneValueSuffix = regexp.MustCompile(`^\s*(#.*)?$`)
+)
+
+// isStandaloneValue returns true if placeholder (starting at line[i]) is the only thing
+// in the YAML node (e.g. "key: ${VAR}", "- ${VAR}" or "- key: ${VAR} # comment").
+func isStandaloneValue(line string, i int, key string) bool {
+	w := 1 + len(key)
+	if i+1 < len(line) && line[i+1] == '{' {
+		w += 2
+	}
+	if i+w > len(line) {
+		return false
+	}
+	prefix, suffix := line[:i], line[i+w:]
+	return strings.Count(prefix, `"`)%2 == 0 && strings.Count(prefix, "'")%2 == 0 &&
+		standaloneValuePrefix.MatchString(prefix) && standaloneValueSuffix.MatchString(suffix)
 }
 
 func expandWithLineColumnInfo(s string, mapping func(string, int, int) (string, bool)) string {
@@ -127,9 +289,16 @@ func getShellName(s string) (string, int) {
 		if len(s) > 2 && isShellSpecialVar(s[1]) && s[2] == '}' {
 			return s[1:2], 3
 		}
+		depth := 0 // ${VAR:-${DEFAULT}}
 		for i := 1; i < len(s); i++ {
-			if s[i] == '}' {
-				return s[1:i], i + 1
+			switch s[i] {
+			case '{':
+				depth++
+			case '}':
+				if depth == 0 {
+					return s[1:i], i + 1
+				}
+				depth--
 			}
 		}
 		return "", 1
@@ -141,3 +310,49 @@ func getShellName(s string) (string, int) {
 	}
 	return s[:i], i
 }
+
+func (t ShellTemplate) Vars() ([]Var, error) {
+	vars := newVarIndex()
+	defaults := make(map[string]string)
+	assigned := make(map[string]bool)
+	var collect func(key string, line int, col int, optional bool)
+	collect = func(key string, line int, col int, optional bool) {
+		if key == "$" || key == "" {
+			return
+		}
+		e := parseShellExpr(key)
+		usage := Usage{Line: line, Column: col, Optional: optional || t.ignoreUnset || assigned[e.name]}
+		switch e.op {
+		case "-", ":-", "=", ":=":
+			usage.Optional = true
+			if _, ok := defaults[e.name]; !ok {
+				defaults[e.name] = e.word
+			}
+			if e.op == "=" || e.op == ":=" {
+				assigned[e.name] = true
+			}
+		case "+", ":+":
+			usage.Optional = true
+		case "?", ":?":
+			usage.Optional = optional
+		}
+		vars.add(e.name, usage)
+		if e.op != ":" {
+			// variables referenced in default/alternate values are only resolved when needed
+			expandWithLineColumnInfo(e.word, func(key string, _ int, _ int) (string, bool) {
+				collect(key, line, col, true)
+				return "", true
+			})
+		}
+	}
+	expandWithLineColumnInfo(string(t.content), func(key string, line int, col int) (string, bool) {
+		collect(key, line, col, false)
+		return "", true
+	})
+	for name, value := range defaults {
+		if v := vars.get(name); v.Default == nil {
+			v.Default = value
+		}
+	}
+	return vars.slice(), nil
+}
@@ -0,0 +1,127 @@
+package engine
+
+import (
+	"bytes"
+	yamlext "github.com/shyiko/kubetpl/yaml"
+	yamlv3 "gopkg.in/yaml.v3"
+	"regexp"
+	"strings"
+)
+
+// ShellTemplateNodeAware makes ShellTemplate substitute variables within YAML scalars only
+// (as opposed to doing it over the whole template as a string). Values are quoted/escaped as needed
+// (meaning that strings containing ":", "#", newlines, etc. are safe to inject) and scalar style is preserved.
+func ShellTemplateNodeAware() ShellTemplateOption {
+	return func(t *ShellTemplate) error {
+		t.nodeAware = true
+		return nil
+	}
+}
+
+var standalonePlaceholder = regexp.MustCompile(`^\$(\{[^}]*\}|[a-zA-Z_][a-zA-Z0-9_]*)$`)
+
+func (t ShellTemplate) renderNodes(data map[string]interface{}) ([]byte, error) {
+	var errs Errors
+	var chunks [][]byte
+	for _, d := range yamlext.Split(t.content) {
+		chunk, chunkLine := d.Content, d.Line
+		var doc yamlv3.Node
+		if err := yamlv3.Unmarshal(chunk, &doc); err != nil {
+			return nil, err
+		}
+		if doc.Kind == 0 {
+			// empty document (or comments only)
+			chunks = append(chunks, chunk)
+			continue
+		}
+		lines := strings.Split(string(chunk), "\n")
+		walkScalars(&doc, func(node *yamlv3.Node) {
+			if err := t.substitute(node, data); err != nil {
+				for _, err := range err.(Errors) {
+					if e, ok := err.(*VarError); ok {
+						// make position relative to the template (instead of the scalar value)
+						switch {
+						case node.Style&(yamlv3.LiteralStyle|yamlv3.FoldedStyle) != 0:
+							// value starts on the line following "|"/">" header (indentation is not a part of it)
+							if i := node.Line + e.Line - 1; i < len(lines) {
+								e.Column += len(lines[i]) - len(strings.TrimLeft(lines[i], " "))
+							}
+							e.Line++
+						case e.Line == 1:
+							e.Column += node.Column - 1
+							if node.Style&(yamlv3.DoubleQuotedStyle|yamlv3.SingleQuotedStyle) != 0 {
+								e.Column++
+							}
+						}
+						e.Line += chunkLine + node.Line - 1
+					}
+					errs = append(errs, err)
+				}
+			}
+		})
+		if errs != nil {
+			continue
+		}
+		var buf bytes.Buffer
+		enc := yamlv3.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if err := enc.Encode(&doc); err != nil {
+			return nil, err
+		}
+		enc.Close()
+		chunks = append(chunks, bytes.TrimSuffix(buf.Bytes(), []byte("\n")))
+	}
+	if errs != nil {
+		return nil, errs
+	}
+	return append(bytes.Join(chunks, []byte("\n---\n")), '\n'), nil
+}
+
+func (t ShellTemplate) substitute(node *yamlv3.Node, data map[string]interface{}) error {
+	if !strings.Contains(node.Value, "$") {
+		return nil
+	}
+	if m := standalonePlaceholder.FindStringSubmatch(node.Value); m != nil && node.Style&yamlv3.TaggedStyle == 0 {
+		key := strings.TrimSuffix(strings.TrimPrefix(m[1], "{"), "}")
+		if e := parseShellExpr(key); e.op == "" {
+			if v, ok := Lookup(data, e.name); ok && v != nil && !yamlext.IsBasicType(v) {
+				// splice map/list as a YAML subtree
+				var n yamlv3.Node
+				if err := n.Encode(yamlext.JSONCompatible(v)); err != nil {
+					return Errors{err}
+				}
+				n.HeadComment, n.LineComment, n.FootComment = node.HeadComment, node.LineComment, node.FootComment
+				*node = n
+				return nil
+			}
+		}
+	}
+	value, err := envsubst(node.Value, data, t.ignoreUnset)
+	if err != nil {
+		return err
+	}
+	node.Value = value
+	if node.Style == 0 {
+		// plain scalars are re-resolved (e.g. "replicas: $REPLICAS" -> int) unless the result can't be represented
+		// as a plain scalar (in which case it's going to be quoted by the encoder)
+		var v interface{}
+		node.Tag = "!!str"
+		if err := yamlv3.Unmarshal([]byte(value), &v); err == nil && v != nil && yamlext.IsBasicType(v) {
+			if _, isString := v.(string); !isString {
+				node.Tag = ""
+			}
+		}
+	}
+	return nil
+}
+
+func walkScalars(node *yamlv3.Node, cb func(node *yamlv3.Node)) {
+	switch node.Kind {
+	case yamlv3.ScalarNode:
+		cb(node)
+	case yamlv3.DocumentNode, yamlv3.SequenceNode, yamlv3.MappingNode:
+		for _, n := range node.Content {
+			walkScalars(n, cb)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package engine
 
 import (
 	log "github.com/sirupsen/logrus"
+	"reflect"
 	"testing"
 )
 
@@ -98,3 +99,243 @@ spec:
 		t.Fatalf("actual: \n%s != expected: \n%s", string(actual), expected)
 	}
 }
+
+func TestShellTemplateVars(t *testing.T) {
+	actual, err := ShellTemplate{
+		content: []byte(`kind: Deployment
+metadata:
+  name: $NAME-deployment
+  annotations:
+    key: "${NAME}$$VALUE"
+spec:
+  replicas: $REPLICAS
+`),
+	}.Vars()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Var{
+		{Name: "NAME", Required: true, Usages: []Usage{{Line: 3, Column: 9}, {Line: 5, Column: 11}}},
+		{Name: "REPLICAS", Required: true, Usages: []Usage{{Line: 7, Column: 13}}},
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("actual: \n%#v != expected: \n%#v", actual, expected)
+	}
+}
+
+func TestShellTemplateRenderReportsAllUnset(t *testing.T) {
+	_, err := ShellTemplate{
+		content: []byte(`kind: Deployment
+metadata:
+  name: $NAME-deployment
+spec:
+  replicas: $REPLICAS
+  selector: x-${SELECTOR}
+`),
+	}.Render(map[string]interface{}{
+		"SELECTOR": []interface{}{"a"},
+	})
+	if err == nil {
+		t.Fatal()
+	}
+	expected := `3:9: "NAME" isn't set
+5:13: "REPLICAS" isn't set
+6:15: "SELECTOR" must be either a string, number or a boolean (maps/lists can only be used as a standalone value (e.g. "key: ${SELECTOR}"))`
+	if err.Error() != expected {
+		t.Fatalf("actual: \n%s != expected: \n%s", err.Error(), expected)
+	}
+	if errs, ok := err.(Errors); !ok || len(errs) != 3 {
+		t.Fatalf("%#v", err)
+	}
+}
+
+func TestShellTemplateRenderNested(t *testing.T) {
+	actual, err := ShellTemplate{
+		content: []byte(`kind: Deployment
+metadata:
+  name: ${app.name}
+  labels: ${app.labels} # spliced
+spec:
+  replicas: ${replicas[1]}
+  template:
+    spec:
+      containers:
+      - name: ${app.name}
+        args:
+        - ${args}
+        env: ${app.env}
+`),
+	}.Render(map[string]interface{}{
+		"app": map[interface{}]interface{}{
+			"name":   "app",
+			"labels": map[interface{}]interface{}{"tier": "backend", "app": "a<b>"},
+			"env":    []interface{}{map[interface{}]interface{}{"name": "K", "value": "V"}},
+		},
+		"replicas": []interface{}{1, 3},
+		"args":     []interface{}{"--verbose", 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `kind: Deployment
+metadata:
+  name: app
+  labels: {"app":"a<b>","tier":"backend"} # spliced
+spec:
+  replicas: 3
+  template:
+    spec:
+      containers:
+      - name: app
+        args:
+        - ["--verbose",1]
+        env: [{"name":"K","value":"V"}]
+`
+	if string(actual) != expected {
+		t.Fatalf("actual: \n%s != expected: \n%s", actual, expected)
+	}
+}
+
+func TestShellTemplateRenderModifiers(t *testing.T) {
+	actual, err := ShellTemplate{
+		content: []byte(`kind: ConfigMap
+data:
+  default: ${UNSET:-default}
+  default-nested: ${UNSET:-${NAME}-x}
+  default-empty: "${EMPTY:-default}|${EMPTY-default}"
+  assign: ${ASSIGNED:=value}|$ASSIGNED
+  alt: "${NAME:+alt}|${UNSET:+alt}"
+  length: ${#NAME}
+  substring: "${NAME:1}|${NAME:1:2}|${NAME: -2}|${NAME:0:-1}"
+  required: ${NAME:?NAME must be set}
+`),
+	}.Render(map[string]interface{}{
+		"NAME":  "value",
+		"EMPTY": "",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `kind: ConfigMap
+data:
+  default: default
+  default-nested: value-x
+  default-empty: "default|"
+  assign: value|value
+  alt: "alt|"
+  length: 5
+  substring: "alue|al|ue|valu"
+  required: value
+`
+	if string(actual) != expected {
+		t.Fatalf("actual: \n%s != expected: \n%s", actual, expected)
+	}
+	_, err = ShellTemplate{content: []byte("kind: ${KIND:?KIND must be set}\nx: ${X?}\n")}.Render(nil)
+	expectedErr := `1:7: "KIND" isn't set (KIND must be set)
+2:4: "X" isn't set`
+	if err == nil || err.Error() != expectedErr {
+		t.Fatalf("actual: \n%v != expected: \n%s", err, expectedErr)
+	}
+}
+
+func TestShellTemplateVarsModifiers(t *testing.T) {
+	actual, err := ShellTemplate{
+		content: []byte(`a: ${A:-${B}}
+c: ${C:=1} $C
+d: ${D:+x} ${D:?}
+`),
+	}.Vars()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Var{
+		{Name: "A", Default: "${B}", Usages: []Usage{{Line: 1, Column: 4, Optional: true}}},
+		{Name: "B", Usages: []Usage{{Line: 1, Column: 4, Optional: true}}},
+		{Name: "C", Default: "1", Usages: []Usage{{Line: 2, Column: 4, Optional: true}, {Line: 2, Column: 12, Optional: true}}},
+		{Name: "D", Required: true, Usages: []Usage{{Line: 3, Column: 4, Optional: true}, {Line: 3, Column: 12}}},
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("actual: \n%#v != expected: \n%#v", actual, expected)
+	}
+}
+
+func TestShellTemplateRenderNodeAware(t *testing.T) {
+	actual, err := Must(NewShellTemplate([]byte(`# kubetpl:syntax:$
+kind: ConfigMap
+metadata:
+  name: $NAME # comment $NOT_A_VAR
+  labels: ${LABELS}
+data:
+  replicas: $REPLICAS
+  colon: $COLON
+  hash: $HASH
+  alias: $ALIAS
+  double-quoted: "$MULTILINE"
+  json: '$JSON'
+  script: |
+    #!/bin/sh
+    echo $NAME
+  script-from-var: $MULTILINE
+---
+kind: Secret
+metadata:
+  name: ${NAME}-secret
+`), ShellTemplateNodeAware())).Render(map[string]interface{}{
+		"NAME":      "app",
+		"LABELS":    map[interface{}]interface{}{"app": "app", "tier": "backend"},
+		"REPLICAS":  3,
+		"COLON":     "key: value",
+		"HASH":      "# not a comment",
+		"ALIAS":     "*ref",
+		"MULTILINE": "line1\nline2",
+		"JSON":      `{"k": 'v'}`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `# kubetpl:syntax:$
+kind: ConfigMap
+metadata:
+  name: app # comment $NOT_A_VAR
+  labels:
+    app: app
+    tier: backend
+data:
+  replicas: 3
+  colon: 'key: value'
+  hash: '# not a comment'
+  alias: '*ref'
+  double-quoted: "line1\nline2"
+  json: '{"k": ''v''}'
+  script: |
+    #!/bin/sh
+    echo app
+  script-from-var: |-
+    line1
+    line2
+---
+kind: Secret
+metadata:
+  name: app-secret
+`
+	if string(actual) != expected {
+		t.Fatalf("actual: \n%s != expected: \n%s", actual, expected)
+	}
+	_, err = Must(NewShellTemplate([]byte("kind: ConfigMap\n---\nmetadata:\n  name: \"x-$NAME\"\n  x: $X\n"),
+		ShellTemplateNodeAware())).Render(nil)
+	expectedErr := `4:12: "NAME" isn't set
+5:6: "X" isn't set`
+	if err == nil || err.Error() != expectedErr {
+		t.Fatalf("actual: \n%v != expected: \n%s", err, expectedErr)
+	}
+	// block scalars (value starts on the line following "|"/">")
+	src := []byte("kind: ConfigMap\ndata:\n  script: |\n    echo a\n    echo ${MISSING}\n  folded: >-\n    $FOLDED\n")
+	expectedErr = `5:10: "MISSING" isn't set
+7:5: "FOLDED" isn't set`
+	for _, opts := range [][]ShellTemplateOption{nil, {ShellTemplateNodeAware()}} {
+		_, err = Must(NewShellTemplate(src, opts...)).Render(nil)
+		if err == nil || err.Error() != expectedErr {
+			t.Fatalf("actual: \n%v != expected: \n%s", err, expectedErr)
+		}
+	}
+}
@@ -8,7 +8,7 @@ import (
 	log "github.com/sirupsen/logrus"
 	yamlext "github.com/shyiko/kubetpl/yaml"
 	"gopkg.in/yaml.v2"
-	"runtime"
+	yamlv3 "gopkg.in/yaml.v3"
 	"strconv"
 	"strings"
 )
@@ -18,21 +18,43 @@ type mixedContentTemplate struct {
 	footer []byte
 }
 
+// regular (non-"kind: Template") resource (kept as is to preserve key order & comments)
+type rawResource []byte
+
 type TemplateKindTemplate struct {
 	Kind         string
 	Objects      []map[interface{}]interface{}
 	Parameters   []TemplateKindTemplateParameter
 	ObjectLabels map[string]string // todo: not implemented
 	dropNull     bool
+	keepOrder    bool
+	source       []byte
+	line         int // line offset of the source within the template
 }
 
 type TemplateKindTemplateParameter struct {
-	Name        string
-	DisplayName string
-	Description string
-	Value       interface{}
-	Required    bool
-	Type        string // string, int, bool or base64 (optional just like rest of the fields (except name))
+	Name        string      `yaml:"name"`
+	DisplayName string      `yaml:"displayName"`
+	Description string      `yaml:"description"`
+	Value       interface{} `yaml:"value"`
+	Required    bool        `yaml:"required"`
+	Type        string      `yaml:"parameterType"` // string, int, bool or base64 (optional just like rest of the fields (except name))
+}
+
+func (p *TemplateKindTemplateParameter) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain TemplateKindTemplateParameter
+	var v struct {
+		plain `yaml:",inline"`
+		Type  string `yaml:"type"` // "parameterType" alias
+	}
+	if err := unmarshal(&v); err != nil {
+		return err
+	}
+	*p = TemplateKindTemplateParameter(v.plain)
+	if p.Type == "" {
+		p.Type = v.Type
+	}
+	return nil
 }
 
 type TemplateKindTemplateOption = func(*TemplateKindTemplate) error
@@ -44,15 +66,26 @@ func TemplateKindTemplateDropNull() TemplateKindTemplateOption {
 	}
 }
 
+// TemplateKindTemplateKeepOrder makes objects keep key order, comments and style of the source
+// (as opposed to being re-marshaled (with keys sorted alphabetically)).
+func TemplateKindTemplateKeepOrder() TemplateKindTemplateOption {
+	return func(t *TemplateKindTemplate) error {
+		t.keepOrder = true
+		return nil
+	}
+}
+
 func NewTemplateKindTemplate(template []byte, options ...TemplateKindTemplateOption) (Template, error) {
 	var doc []interface{}
-	for _, chunk := range yamlext.Chunk(template) {
+	for _, d := range yamlext.Split(template) {
+		chunk := d.Content
 		var tpl TemplateKindTemplate
 		err := yaml.Unmarshal(chunk, &tpl)
 		if err != nil {
 			return nil, err
 		}
 		if tpl.Kind == "Template" {
+			tpl.source, tpl.line = chunk, d.Line
 			for _, option := range options {
 				if err := option(&tpl); err != nil {
 					return nil, err
@@ -73,12 +106,13 @@ func NewTemplateKindTemplate(template []byte, options ...TemplateKindTemplateOpt
 		if m["kind"] == nil || m["kind"] == "" {
 			return nil, errors.New("Resource \"kind\" is missing")
 		}
-		doc = append(doc, m)
+		doc = append(doc, rawResource(chunk))
 	}
 	return mixedContentTemplate{doc, yamlext.Footer(template)}, nil
 }
 
 func (t mixedContentTemplate) Render(data map[string]interface{}) ([]byte, error) {
+	var errs Errors
 	var buf bytes.Buffer
 	for _, doc := range t.doc {
 		var res []byte
@@ -87,38 +121,116 @@ func (t mixedContentTemplate) Render(data map[string]interface{}) ([]byte, error
 		case TemplateKindTemplate:
 			res, err = d.Render(data)
 			if err != nil {
+				if e, ok := err.(Errors); ok {
+					errs = append(errs, e...)
+					continue
+				}
 				return nil, err
 			}
 			buf.Write(res)
-		default:
-			res, err = yaml.Marshal(d)
-			if err != nil {
-				return nil, err
-			}
+		case rawResource:
 			buf.Write([]byte("---\n"))
-			buf.Write(res)
+			buf.Write(d)
+			buf.Write([]byte("\n"))
 		}
 	}
-	buf.Write(t.footer)
+	if errs != nil {
+		return nil, errs
+	}
+	if n := len(t.doc); n == 0 {
+		buf.Write(t.footer)
+	} else if _, ok := t.doc[n-1].(rawResource); !ok {
+		// footer of the last resource is a part of it
+		buf.Write(t.footer)
+	}
 	return buf.Bytes(), nil
 }
 
-func (t TemplateKindTemplate) Render(data map[string]interface{}) (res []byte, err error) {
-	defer func() {
-		if r := recover(); r != nil {
-			if _, ok := r.(runtime.Error); ok {
-				panic(r)
+func (t mixedContentTemplate) Vars() ([]Var, error) {
+	vars := newVarIndex()
+	for _, doc := range t.doc {
+		if d, ok := doc.(TemplateKindTemplate); ok {
+			d.collectVars(vars)
+		}
+	}
+	return vars.slice(), nil
+}
+
+func (t TemplateKindTemplate) Vars() ([]Var, error) {
+	vars := newVarIndex()
+	t.collectVars(vars)
+	return vars.slice(), nil
+}
+
+func (t TemplateKindTemplate) collectVars(vars *varIndex) {
+	declared := make(map[string]bool)
+	for _, p := range t.Parameters {
+		v := vars.get(p.Name)
+		v.Default = p.Value
+		v.Description = p.Description
+		v.Type = p.Type
+		v.Required = p.Required && p.Value == nil
+		declared[p.Name] = true
+	}
+	t.scan(func(name string, usage Usage) {
+		if declared[name] {
+			v := vars.get(name)
+			v.Usages = append(v.Usages, usage)
+		} else {
+			vars.add(name, usage)
+		}
+	})
+}
+
+// scan calls cb for each $(NAME)/$((NAME)) found in the source of the template.
+func (t TemplateKindTemplate) scan(cb func(name string, usage Usage)) {
+	for i, line := range strings.Split(string(t.source), "\n") {
+		for j := 0; j < len(line); j++ {
+			if line[j] != '$' || j+1 == len(line) {
+				continue
 			}
-			err = r.(error)
+			name, w := extractName(line[j:])
+			if w == 0 {
+				continue
+			}
+			if strings.HasPrefix(name, "(") && strings.HasSuffix(name, ")") {
+				name = name[1 : len(name)-1]
+			}
+			cb(name, Usage{Line: t.line + i + 1, Column: j + 1})
+			j += w - 1
+		}
+	}
+}
+
+// varErrors returns an error for each usage of the variable (or a single position-less error if there are none).
+func (t TemplateKindTemplate) varErrors(name string, message string) Errors {
+	var errs Errors
+	t.scan(func(n string, usage Usage) {
+		if n == name {
+			errs = append(errs, &VarError{Name: name, Line: usage.Line, Column: usage.Column, Message: message})
+		}
+	})
+	if errs == nil {
+		errs = Errors{&VarError{Name: name, Message: message}}
+	}
+	return errs
+}
+
+func (t TemplateKindTemplate) Render(data map[string]interface{}) ([]byte, error) {
+	data, errs := t.data(data)
+	reported := make(map[string]bool)
+	for _, err := range errs {
+		if e, ok := err.(*VarError); ok {
+			reported[e.Name] = true
 		}
-	}()
-	data, err = t.data(data)
-	if err != nil {
-		return nil, err
 	}
 	log.Debugf("data = %v", data)
+	var nodes []*yamlv3.Node
+	if t.keepOrder {
+		nodes = t.objectNodes()
+	}
 	var buf bytes.Buffer
-	for _, obj := range t.Objects {
+	for i, obj := range t.Objects {
 		uobj := t.traverse(
 			obj,
 			func(value string) interface{} {
@@ -131,13 +243,22 @@ func (t TemplateKindTemplate) Render(data map[string]interface{}) (res []byte, e
 					}
 					v, ok := data[name]
 					if !ok {
-						panic(fmt.Errorf("\"%s\" isn't set", name))
+						if !reported[name] {
+							reported[name] = true
+							errs = append(errs, t.varErrors(name, fmt.Sprintf("\"%s\" isn't set", name))...)
+						}
+						return ""
 					}
 					if v == nil {
 						null = true
 					}
-					if !yamlext.IsBasicType(value) {
-						panic(fmt.Errorf("\"%s\" must be either a string, number or a boolean", name))
+					if !yamlext.IsBasicType(v) {
+						if !reported[name] {
+							reported[name] = true
+							errs = append(errs, t.varErrors(name,
+								fmt.Sprintf("\"%s\" must be either a string, number or a boolean", name))...)
+						}
+						return ""
 					}
 					return fmt.Sprintf("%v", v)
 				})
@@ -165,22 +286,56 @@ func (t TemplateKindTemplate) Render(data map[string]interface{}) (res []byte, e
 				return value
 			},
 		)
-		b, err := yaml.Marshal(uobj)
+		if errs != nil {
+			continue
+		}
+		var b []byte
+		var err error
+		if nodes != nil {
+			// preserve key order & comments
+			yamlext.SyncNode(nodes[i], uobj)
+			b, err = yamlext.MarshalNode(nodes[i])
+		} else {
+			b, err = yaml.Marshal(uobj)
+		}
 		if err != nil {
 			return nil, err
 		}
 		buf.Write([]byte("---\n"))
 		buf.Write(b)
 	}
+	if errs != nil {
+		return nil, errs
+	}
 	return buf.Bytes(), nil
 }
 
-func (t TemplateKindTemplate) data(param map[string]interface{}) (map[string]interface{}, error) {
+// objectNodes returns "objects" as they appear in the source (nil if not available).
+func (t TemplateKindTemplate) objectNodes() []*yamlv3.Node {
+	doc, err := yamlext.ParseNode(t.source)
+	if err != nil || len(doc.Content) == 0 || doc.Content[0].Kind != yamlv3.MappingNode {
+		return nil
+	}
+	m := doc.Content[0]
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == "objects" {
+			if seq := m.Content[i+1]; seq.Kind == yamlv3.SequenceNode && len(seq.Content) == len(t.Objects) {
+				return seq.Content
+			}
+			break
+		}
+	}
+	return nil
+}
+
+func (t TemplateKindTemplate) data(param map[string]interface{}) (map[string]interface{}, Errors) {
+	var errs Errors
 	m := make(map[string]interface{}, len(param))
 	for _, p := range t.Parameters {
 		if param[p.Name] == nil {
 			if p.Required && p.Value == nil {
-				return nil, fmt.Errorf("\"%s\" isn't set", p.Name)
+				errs = append(errs, t.varErrors(p.Name, fmt.Sprintf("\"%s\" isn't set", p.Name))...)
+				continue
 			}
 			m[p.Name] = p.Value
 		}
@@ -190,35 +345,46 @@ func (t TemplateKindTemplate) data(param map[string]interface{}) (map[string]int
 	}
 	// enforce p.Type
 	for _, p := range t.Parameters {
-		if p.Type == "" {
-			continue // type is optional
+		if err := ValidateParameterType(p.Name, p.Type, m[p.Name]); err != nil {
+			errs = append(errs, t.varErrors(p.Name, err.Error())...)
 		}
-		switch p.Type {
-		case "string", "base64", "int", "bool":
-			break
-		default:
-			return nil, fmt.Errorf("\"parameterType\" of \"%s\" must be either string, base64, int or bool", p.Name)
+	}
+	return m, errs
+}
+
+// ValidateParameterType checks that value of the parameter matches "parameterType"
+// (string, base64, int or bool; empty parameterType and null values are accepted as is).
+func ValidateParameterType(name string, parameterType string, v interface{}) error {
+	if parameterType == "" {
+		return nil // type is optional
+	}
+	switch parameterType {
+	case "string", "base64", "int", "bool":
+		break
+	default:
+		return fmt.Errorf("\"parameterType\" of \"%s\" must be either string, base64, int or bool", name)
+	}
+	if v == nil {
+		return nil
+	}
+	if !yamlext.IsBasicType(v) {
+		return fmt.Errorf("Type of \"%s\" must be \"%s\"", name, parameterType)
+	}
+	switch parameterType {
+	case "base64":
+		if !isBase64EncodedString(v) {
+			return fmt.Errorf("\"%s\" must be a base64-encoded string", name)
 		}
-		v := m[p.Name]
-		if !yamlext.IsBasicType(v) {
-			return nil, fmt.Errorf("Type of \"%s\" must be \"%s\"", p.Name, p.Type)
+	case "int":
+		if !isInt(v) {
+			return fmt.Errorf("\"%s\" must be a number", name)
 		}
-		switch p.Type {
-		case "base64":
-			if !isBase64EncodedString(v) {
-				return nil, fmt.Errorf("\"%s\" must be a base64-encoded string", p.Name)
-			}
-		case "int":
-			if !isInt(v) {
-				return nil, fmt.Errorf("\"%s\" must be a number", p.Name)
-			}
-		case "bool":
-			if !isBool(v) {
-				return nil, fmt.Errorf("\"%s\" must be a boolean", p.Name)
-			}
+	case "bool":
+		if !isBool(v) {
+			return fmt.Errorf("\"%s\" must be a boolean", name)
 		}
 	}
-	return m, nil
+	return nil
 }
 
 func isBase64EncodedString(v interface{}) bool {
@@ -2,6 +2,7 @@ package engine
 
 import (
 	log "github.com/sirupsen/logrus"
+	"reflect"
 	"testing"
 )
 
@@ -95,7 +96,7 @@ parameters:
 	)).Render(map[string]interface{}{
 		"NOT_USED": "value",
 	})
-	if err == nil || err.Error() != `"NAME" isn't set` {
+	if err == nil || err.Error() != `9:15: "NAME" isn't set` {
 		t.Fatal()
 	}
 }
@@ -168,3 +169,63 @@ metadata:
 		t.Fatalf("actual: \n%s != expected: \n%s", actual, expected)
 	}
 }
+
+func TestKindTemplateVars(t *testing.T) {
+	actual, err := Must(NewTemplateKindTemplate(
+		[]byte(`kind: ConfigMap
+metadata:
+  name: $(IGNORED)
+---
+kind: Template
+objects:
+- kind: Deployment
+  metadata:
+    name: deploy-$(NAME)-$(ID)
+  spec:
+    replicas: $((REPLICAS))
+parameters:
+- name: NAME
+  required: true
+- name: REPLICAS
+  description: Number of replicas
+  value: 1
+  required: true
+`)),
+	).(VarLister).Vars()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Var{
+		{Name: "NAME", Required: true, Usages: []Usage{{Line: 9, Column: 18}}},
+		{Name: "REPLICAS", Default: 1, Description: "Number of replicas", Usages: []Usage{{Line: 11, Column: 15}}},
+		{Name: "ID", Required: true, Usages: []Usage{{Line: 9, Column: 26}}},
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("actual: \n%#v != expected: \n%#v", actual, expected)
+	}
+}
+
+func TestKindTemplateRenderParameterType(t *testing.T) {
+	tpl := Must(NewTemplateKindTemplate(
+		[]byte(`kind: Template
+objects:
+- kind: Deployment
+  metadata:
+    name: $(NAME)
+  spec:
+    replicas: $((REPLICAS))
+parameters:
+- name: NAME
+  type: string
+- name: REPLICAS
+  parameterType: int
+`),
+	))
+	if _, err := tpl.Render(map[string]interface{}{"NAME": "app", "REPLICAS": 2}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := tpl.Render(map[string]interface{}{"NAME": "app", "REPLICAS": "two"})
+	if err == nil || err.Error() != `7:15: "REPLICAS" must be a number` {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,90 @@
+package engine
+
+import "fmt"
+
+// Var describes a variable referenced by a template.
+type Var struct {
+	Name string `json:"name"`
+	// true if rendering is going to fail unless variable is set
+	Required bool `json:"required"`
+	// default value (if any)
+	Default     interface{} `json:"default,omitempty"`
+	Description string      `json:"description,omitempty"`
+	Type        string      `json:"type,omitempty"`
+	Usages      []Usage     `json:"usages"`
+}
+
+// Usage is a position (1-based) at which variable is referenced.
+type Usage struct {
+	// file the usage is located in, if other than the template itself (e.g. "# kubetpl:include:"d file)
+	File   string `json:"file,omitempty"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+	// true if usage is guarded (e.g. by "isset"/"get" in go-template)
+	Optional bool `json:"optional,omitempty"`
+}
+
+// String returns "[<file>:]<line>:<column>".
+func (u Usage) String() string {
+	if u.File != "" {
+		return fmt.Sprintf("%s:%d:%d", u.File, u.Line, u.Column)
+	}
+	return fmt.Sprintf("%d:%d", u.Line, u.Column)
+}
+
+// VarLister is implemented by templates capable of reporting variables they reference.
+type VarLister interface {
+	Vars() ([]Var, error)
+}
+
+type varIndex struct {
+	vars  []*Var
+	index map[string]*Var
+}
+
+func newVarIndex() *varIndex {
+	return &varIndex{index: make(map[string]*Var)}
+}
+
+func (x *varIndex) get(name string) *Var {
+	v, ok := x.index[name]
+	if !ok {
+		v = &Var{Name: name}
+		x.index[name] = v
+		x.vars = append(x.vars, v)
+	}
+	return v
+}
+
+func (x *varIndex) add(name string, usage Usage) {
+	v := x.get(name)
+	v.Usages = append(v.Usages, usage)
+	if !usage.Optional && v.Default == nil {
+		v.Required = true
+	}
+}
+
+func (x *varIndex) slice() []Var {
+	r := make([]Var, 0, len(x.vars))
+	for _, v := range x.vars {
+		if v.Usages == nil {
+			v.Usages = []Usage{}
+		}
+		r = append(r, *v)
+	}
+	return r
+}
+
+// lineColumn converts byte offset into (1-based) line/column.
+func lineColumn(s string, offset int) (int, int) {
+	line, col := 1, 1
+	for i := 0; i < offset && i < len(s); i++ {
+		if s[i] == '\n' {
+			line++
+			col = 1
+		} else {
+			col++
+		}
+	}
+	return line, col
+}
@@ -0,0 +1,287 @@
+package kube
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client fetches objects from Kubernetes API server.
+type Client struct {
+	Server string
+	// Namespace objects without "metadata.namespace" are assumed to be in.
+	Namespace string
+	HTTP      *http.Client
+	token     string
+	username  string
+	password  string
+	resources map[string][]apiResource // groupVersion -> resources
+}
+
+type apiResource struct {
+	Name       string `json:"name"`
+	Namespaced bool   `json:"namespaced"`
+	Kind       string `json:"kind"`
+}
+
+// NewClient returns a Client for a given kubeconfig context (current-context if empty).
+func NewClient(config *Config, context string) (*Client, error) {
+	cluster, user, namespace, err := config.Resolve(context)
+	if err != nil {
+		return nil, err
+	}
+	if cluster.Server == "" {
+		return nil, fmt.Errorf(`"server" is not set (context "%s")`, context)
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: cluster.InsecureSkipTLSVerify}
+	ca, err := readData(cluster.CertificateAuthorityData, cluster.CertificateAuthority)
+	if err != nil {
+		return nil, err
+	}
+	if len(ca) != 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("failed to parse certificate-authority")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	cert, err := readData(user.ClientCertificateData, user.ClientCertificate)
+	if err != nil {
+		return nil, err
+	}
+	key, err := readData(user.ClientKeyData, user.ClientKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(cert) != 0 || len(key) != 0 {
+		pair, err := tls.X509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{pair}
+	}
+	token := user.Token
+	if token == "" && user.TokenFile != "" {
+		b, err := ioutil.ReadFile(user.TokenFile)
+		if err != nil {
+			return nil, err
+		}
+		token = strings.TrimSpace(string(b))
+	}
+	return &Client{
+		Server:    strings.TrimSuffix(cluster.Server, "/"),
+		Namespace: namespace,
+		HTTP: &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig, Proxy: http.ProxyFromEnvironment},
+		},
+		token:     token,
+		username:  user.Username,
+		password:  user.Password,
+		resources: make(map[string][]apiResource),
+	}, nil
+}
+
+// errNotFound is returned by get in case of 404.
+var errNotFound = fmt.Errorf("not found")
+
+func (c *Client) get(path string) ([]byte, error) {
+	req, err := http.NewRequest("GET", c.Server+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	} else if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		var status struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(body, &status) == nil && status.Message != "" {
+			return nil, fmt.Errorf("GET %s: %s (%s)", path, resp.Status, status.Message)
+		}
+		return nil, fmt.Errorf("GET %s: %s", path, resp.Status)
+	}
+	return body, nil
+}
+
+func groupVersionPath(apiVersion string) string {
+	if strings.Contains(apiVersion, "/") {
+		return "/apis/" + apiVersion
+	}
+	return "/api/" + apiVersion
+}
+
+// resource returns REST resource (e.g. "deployments") corresponding to a given kind.
+func (c *Client) resource(apiVersion string, kind string) (*apiResource, error) {
+	resources, ok := c.resources[apiVersion]
+	if !ok {
+		body, err := c.get(groupVersionPath(apiVersion))
+		if err != nil && err != errNotFound {
+			return nil, err
+		}
+		if err == nil {
+			var list struct {
+				Resources []apiResource `json:"resources"`
+			}
+			if err := json.Unmarshal(body, &list); err != nil {
+				return nil, err
+			}
+			for _, r := range list.Resources {
+				if !strings.Contains(r.Name, "/") { // subresources (e.g. deployments/scale) are ignored
+					resources = append(resources, r)
+				}
+			}
+		}
+		c.resources[apiVersion] = resources
+	}
+	for i := range resources {
+		if resources[i].Kind == kind {
+			return &resources[i], nil
+		}
+	}
+	return nil, fmt.Errorf(`%s/%s is not served by %s`, apiVersion, kind, c.Server)
+}
+
+// Get returns live version of the object (or nil if object does not exist).
+func (c *Client) Get(obj map[interface{}]interface{}) (map[interface{}]interface{}, error) {
+	apiVersion, _ := obj["apiVersion"].(string)
+	kind, _ := obj["kind"].(string)
+	meta, _ := obj["metadata"].(map[interface{}]interface{})
+	name, _ := meta["name"].(string)
+	namespace, _ := meta["namespace"].(string)
+	if apiVersion == "" || kind == "" || name == "" {
+		return nil, fmt.Errorf("apiVersion, kind and metadata.name must be set (got %s/%s/%s)", apiVersion, kind, name)
+	}
+	r, err := c.resource(apiVersion, kind)
+	if err != nil {
+		return nil, err
+	}
+	path := groupVersionPath(apiVersion)
+	if r.Namespaced {
+		if namespace == "" {
+			namespace = c.Namespace
+		}
+		path += "/namespaces/" + url.PathEscape(namespace)
+	}
+	path += "/" + r.Name + "/" + url.PathEscape(name)
+	body, err := c.get(path)
+	if err != nil {
+		if err == errNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	// JSON is a subset of YAML (yaml.v2 is used to get the same representation as rendered objects have (int vs float64))
+	live := make(map[interface{}]interface{})
+	if err := yaml.Unmarshal(body, &live); err != nil {
+		return nil, err
+	}
+	return live, nil
+}
+
+// serverManagedFields are dropped from live objects before comparison.
+var serverManagedFields = []string{"creationTimestamp", "generation", "managedFields", "resourceVersion", "selfLink", "uid"}
+
+// Normalize returns a copy of live stripped of everything that desired does not set
+// (server-managed fields, defaults, status, etc) so that the two could be compared.
+func Normalize(desired, live map[interface{}]interface{}) map[interface{}]interface{} {
+	live, _ = prune(desired, live).(map[interface{}]interface{})
+	if meta, ok := live["metadata"].(map[interface{}]interface{}); ok {
+		for _, field := range serverManagedFields {
+			delete(meta, field)
+		}
+		if annotations, ok := meta["annotations"].(map[interface{}]interface{}); ok {
+			delete(annotations, "kubectl.kubernetes.io/last-applied-configuration")
+		}
+	}
+	return live
+}
+
+// prune removes from live values that aren't present in desired (map keys and list items matched by "name" if
+// every item has one).
+func prune(desired, live interface{}) interface{} {
+	switch d := desired.(type) {
+	case map[interface{}]interface{}:
+		l, ok := live.(map[interface{}]interface{})
+		if !ok {
+			return live
+		}
+		r := make(map[interface{}]interface{}, len(d))
+		for k, v := range l {
+			if dv, ok := d[k]; ok {
+				r[k] = prune(dv, v)
+			}
+		}
+		return r
+	case []interface{}:
+		l, ok := live.([]interface{})
+		if !ok {
+			return live
+		}
+		dn, ln := namedItems(d), namedItems(l)
+		r := make([]interface{}, 0, len(l))
+		for i, v := range l {
+			switch {
+			case dn != nil && ln != nil:
+				name := v.(map[interface{}]interface{})["name"]
+				if dv, ok := dn[name]; ok {
+					r = append(r, prune(dv, v))
+				} else {
+					r = append(r, v)
+				}
+			case i < len(d):
+				r = append(r, prune(d[i], v))
+			default:
+				r = append(r, v)
+			}
+		}
+		return r
+	case nil:
+		return live
+	default:
+		// e.g. "cpu: 1" vs "cpu: \"1\"" (as returned by the API server)
+		if s, ok := live.(string); ok && s == fmt.Sprintf("%v", desired) {
+			return desired
+		}
+		return live
+	}
+}
+
+func namedItems(items []interface{}) map[interface{}]interface{} {
+	m := make(map[interface{}]interface{}, len(items))
+	for _, item := range items {
+		obj, ok := item.(map[interface{}]interface{})
+		if !ok {
+			return nil
+		}
+		name, ok := obj["name"].(string)
+		if !ok {
+			return nil
+		}
+		m[name] = item
+	}
+	return m
+}
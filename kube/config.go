@@ -0,0 +1,181 @@
+// Package kube implements a minimal (read-only) Kubernetes API client used by "kubetpl render --diff-against".
+package kube
+
+import (
+	"encoding/base64"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Config is a subset of kubeconfig (https://kubernetes.io/docs/concepts/configuration/organize-cluster-access-kubeconfig/).
+type Config struct {
+	CurrentContext string `yaml:"current-context"`
+	Clusters       []struct {
+		Name    string  `yaml:"name"`
+		Cluster Cluster `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Users []struct {
+		Name string   `yaml:"name"`
+		User AuthInfo `yaml:"user"`
+	} `yaml:"users"`
+	Contexts []struct {
+		Name    string  `yaml:"name"`
+		Context Context `yaml:"context"`
+	} `yaml:"contexts"`
+	// directory of the kubeconfig file (relative paths are resolved against it)
+	dir string
+}
+
+type Cluster struct {
+	Server                   string `yaml:"server"`
+	CertificateAuthority     string `yaml:"certificate-authority"`
+	CertificateAuthorityData string `yaml:"certificate-authority-data"`
+	InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+}
+
+type AuthInfo struct {
+	ClientCertificate     string      `yaml:"client-certificate"`
+	ClientCertificateData string      `yaml:"client-certificate-data"`
+	ClientKey             string      `yaml:"client-key"`
+	ClientKeyData         string      `yaml:"client-key-data"`
+	Token                 string      `yaml:"token"`
+	TokenFile             string      `yaml:"tokenFile"`
+	Username              string      `yaml:"username"`
+	Password              string      `yaml:"password"`
+	Exec                  interface{} `yaml:"exec"`
+	AuthProvider          interface{} `yaml:"auth-provider"`
+}
+
+type Context struct {
+	Cluster   string `yaml:"cluster"`
+	User      string `yaml:"user"`
+	Namespace string `yaml:"namespace"`
+}
+
+// DefaultConfigPaths returns $KUBECONFIG entries (or ~/.kube/config if $KUBECONFIG is not set).
+func DefaultConfigPaths() []string {
+	if env := os.Getenv("KUBECONFIG"); env != "" {
+		return filepath.SplitList(env)
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil
+	}
+	return []string{filepath.Join(home, ".kube", "config")}
+}
+
+// LoadConfig reads kubeconfig file(s). Just like with kubectl, the first file to define a
+// cluster/user/context/current-context wins.
+func LoadConfig(paths ...string) (*Config, error) {
+	var r *Config
+	for _, path := range paths {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			if os.IsNotExist(err) && len(paths) > 1 {
+				continue
+			}
+			return nil, err
+		}
+		var c Config
+		if err := yaml.Unmarshal(b, &c); err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err.Error())
+		}
+		dir, err := filepath.Abs(filepath.Dir(path))
+		if err != nil {
+			return nil, err
+		}
+		c.resolvePaths(dir)
+		if r == nil {
+			r = &c
+			continue
+		}
+		if r.CurrentContext == "" {
+			r.CurrentContext = c.CurrentContext
+		}
+		r.Clusters = append(r.Clusters, c.Clusters...)
+		r.Users = append(r.Users, c.Users...)
+		r.Contexts = append(r.Contexts, c.Contexts...)
+	}
+	if r == nil {
+		return nil, fmt.Errorf("kubeconfig not found (tried %s)", strings.Join(paths, ", "))
+	}
+	return r, nil
+}
+
+func (c *Config) resolvePaths(dir string) {
+	resolve := func(path *string) {
+		if *path != "" && !filepath.IsAbs(*path) {
+			*path = filepath.Join(dir, *path)
+		}
+	}
+	for i := range c.Clusters {
+		resolve(&c.Clusters[i].Cluster.CertificateAuthority)
+	}
+	for i := range c.Users {
+		u := &c.Users[i].User
+		resolve(&u.ClientCertificate)
+		resolve(&u.ClientKey)
+		resolve(&u.TokenFile)
+	}
+}
+
+// Resolve returns cluster, user and namespace of a given context (current-context if empty).
+func (c *Config) Resolve(context string) (*Cluster, *AuthInfo, string, error) {
+	if context == "" {
+		context = c.CurrentContext
+	}
+	if context == "" {
+		return nil, nil, "", fmt.Errorf("current-context is not set (please specify context explicitly)")
+	}
+	var ctx *Context
+	for i := range c.Contexts {
+		if c.Contexts[i].Name == context {
+			ctx = &c.Contexts[i].Context
+			break
+		}
+	}
+	if ctx == nil {
+		return nil, nil, "", fmt.Errorf(`context "%s" not found in kubeconfig`, context)
+	}
+	var cluster *Cluster
+	for i := range c.Clusters {
+		if c.Clusters[i].Name == ctx.Cluster {
+			cluster = &c.Clusters[i].Cluster
+			break
+		}
+	}
+	if cluster == nil {
+		return nil, nil, "", fmt.Errorf(`cluster "%s" (context "%s") not found in kubeconfig`, ctx.Cluster, context)
+	}
+	user := &AuthInfo{}
+	for i := range c.Users {
+		if c.Users[i].Name == ctx.User {
+			user = &c.Users[i].User
+			break
+		}
+	}
+	if user.Exec != nil || user.AuthProvider != nil {
+		return nil, nil, "", fmt.Errorf(`user "%s" (context "%s"): exec/auth-provider credentials are not supported`+
+			" (use token, client certificate or basic auth)", ctx.User, context)
+	}
+	namespace := ctx.Namespace
+	if namespace == "" {
+		namespace = "default"
+	}
+	return cluster, user, namespace, nil
+}
+
+// readData returns base64-decoded data (if set) or the content of the file.
+func readData(data string, file string) ([]byte, error) {
+	if data != "" {
+		return base64.StdEncoding.DecodeString(data)
+	}
+	if file != "" {
+		return ioutil.ReadFile(file)
+	}
+	return nil, nil
+}
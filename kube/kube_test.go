@@ -0,0 +1,127 @@
+package kube
+
+import (
+	"github.com/shyiko/kubetpl/render"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiffAgainstLive(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/v1":
+			w.Write([]byte(`{"kind":"APIResourceList","groupVersion":"v1","resources":[
+				{"name":"configmaps","namespaced":true,"kind":"ConfigMap"},
+				{"name":"namespaces","namespaced":false,"kind":"Namespace"}]}`))
+		case "/apis/apps/v1":
+			w.Write([]byte(`{"kind":"APIResourceList","groupVersion":"apps/v1","resources":[
+				{"name":"deployments","namespaced":true,"kind":"Deployment"},
+				{"name":"deployments/scale","namespaced":true,"kind":"Scale"}]}`))
+		case "/api/v1/namespaces/staging/configmaps/app":
+			w.Write([]byte(`{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"app","namespace":"staging",
+				"uid":"1","resourceVersion":"2","creationTimestamp":"2020-01-01T00:00:00Z",
+				"managedFields":[{"manager":"kubectl"}],
+				"annotations":{"kubectl.kubernetes.io/last-applied-configuration":"{}"}},
+				"data":{"key":"old"}}`))
+		case "/api/v1/namespaces/app":
+			w.Write([]byte(`{"apiVersion":"v1","kind":"Namespace","metadata":{"name":"app","uid":"3"},
+				"spec":{"finalizers":["kubernetes"]},"status":{"phase":"Active"}}`))
+		case "/apis/apps/v1/namespaces/staging/deployments/app":
+			w.Write([]byte(`{"apiVersion":"apps/v1","kind":"Deployment","metadata":{"name":"app","namespace":"staging"},
+				"spec":{"replicas":1,"revisionHistoryLimit":10,"template":{"spec":{"containers":[
+				{"name":"app","image":"app:1","imagePullPolicy":"IfNotPresent",
+				"resources":{"limits":{"cpu":"1"}}}]}}},"status":{"replicas":1}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "kubetpl-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "token"), []byte("secret\n"), 0600)
+	kubeconfig := filepath.Join(dir, "config")
+	ioutil.WriteFile(kubeconfig, []byte(`apiVersion: v1
+kind: Config
+current-context: prod
+clusters:
+- name: local
+  cluster:
+    server: `+server.URL+`
+users:
+- name: local
+  user:
+    tokenFile: token
+contexts:
+- name: staging
+  context:
+    cluster: local
+    user: local
+    namespace: staging
+`), 0600)
+	config, err := LoadConfig(kubeconfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewClient(config, ""); err == nil {
+		t.Fatal("expected unknown context to be reported")
+	}
+	client, err := NewClient(config, "staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var desired []map[interface{}]interface{}
+	for _, s := range []string{
+		"apiVersion: v1\nkind: ConfigMap\nmetadata: {name: app}\ndata: {key: new}",
+		"apiVersion: v1\nkind: Namespace\nmetadata: {name: app}",
+		"apiVersion: v1\nkind: ConfigMap\nmetadata: {name: missing}",
+		"apiVersion: apps/v1\nkind: Deployment\nmetadata: {name: app}\nspec:\n  replicas: 2\n  template:\n" +
+			"    spec:\n      containers:\n      - {name: app, image: 'app:1', resources: {limits: {cpu: 1}}}",
+	} {
+		obj := make(map[interface{}]interface{})
+		if err := yaml.Unmarshal([]byte(s), &obj); err != nil {
+			t.Fatal(err)
+		}
+		desired = append(desired, obj)
+	}
+	var live []map[interface{}]interface{}
+	for _, obj := range desired {
+		l, err := client.Get(obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if l != nil {
+			live = append(live, Normalize(obj, l))
+		}
+	}
+	diff, err := render.Diff(live, desired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual := render.FormatDiff(diff)
+	expected := `~ ConfigMap/app
+    ~ data.key: "old" -> "new"
++ ConfigMap/missing
+~ Deployment/app
+    ~ spec.replicas: 1 -> 2
+`
+	if actual != expected {
+		t.Fatalf("actual: \n%s != expected: \n%s", actual, expected)
+	}
+	if _, err := client.Get(map[interface{}]interface{}{
+		"apiVersion": "example.com/v1", "kind": "Unknown", "metadata": map[interface{}]interface{}{"name": "x"},
+	}); err == nil {
+		t.Fatal("expected unknown kind to be reported")
+	}
+}
@@ -2,21 +2,21 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"github.com/shyiko/kubetpl/cli"
-	"github.com/shyiko/kubetpl/dotenv"
-	"github.com/shyiko/kubetpl/engine"
-	"github.com/shyiko/kubetpl/engine/processor"
-	yamlext "github.com/shyiko/kubetpl/yaml"
+	"github.com/shyiko/kubetpl/kube"
+	"github.com/shyiko/kubetpl/render"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"gopkg.in/yaml.v2"
+	"io"
 	"io/ioutil"
-	"net/http"
 	"os"
-	"path/filepath"
 	"strings"
+	"text/tabwriter"
+	"time"
 )
 
 var version string
@@ -38,9 +38,6 @@ func (f *simpleFormatter) Format(entry *log.Entry) ([]byte, error) {
 	return b.Bytes(), nil
 }
 
-const directiveSyntax = "syntax"
-const directiveSet = "set"
-
 func main() {
 	completion := cli.NewCompletion()
 	completed, err := completion.Execute()
@@ -51,9 +48,10 @@ func main() {
 	if completed {
 		os.Exit(0)
 	}
-	var syntax, chroot string
-	var configFiles, configKeyValuePairs, freezeRefs, freezeList []string
-	var allowFsAccess, ignoreUnset, freeze bool
+	var chroot string
+	var configFiles, freezeRefs, freezeList []string
+	var configKeyValuePairs []configOverride
+	var allowFsAccess, ignoreUnset, nodeAware, freeze bool
 	rootCmd := &cobra.Command{
 		Use:  "kubetpl",
 		Long: "Kubernetes templates made easy (https://github.com/shyiko/kubetpl).",
@@ -78,18 +76,8 @@ func main() {
 			if len(args) == 0 {
 				return pflag.ErrHelp
 			}
-			config, err := readConfigFiles(configFiles...)
-			if err != nil {
-				log.Fatal(err)
-			}
-			for _, pair := range configKeyValuePairs {
-				split := strings.SplitN(pair, "=", 2)
-				if len(split) != 2 {
-					log.Fatalf("Expected <key>=<value> pair, instead got %#v", pair)
-				}
-				config[split[0]] = split[1]
-			}
 			var formatSlice []string
+			syntax, _ := cmd.Flags().GetString("syntax")
 			if syntax != "" {
 				formatSlice = append(formatSlice, syntax)
 			}
@@ -126,45 +114,181 @@ func main() {
 				}
 				normalizedFreezeList = append(normalizedFreezeList, ref)
 			}
-			out, err := render(args, config, renderOpts{
-				format:            explicitFormat,
-				chroot:            chroot,
-				chrootTemplateDir: allowFsAccess,
-				freeze:            freeze,
-				freezeRefs:        freezeRefs,
-				freezeList:        normalizedFreezeList,
-				ignoreUnset:       ignoreUnset,
-			})
-			if err != nil {
-				log.Fatal(err)
+			freezePaths, _ := cmd.Flags().GetStringArray("freeze-path")
+			freezePathsFiles, _ := cmd.Flags().GetStringArray("freeze-paths-file")
+			freezeHash, _ := cmd.Flags().GetString("freeze-hash")
+			freezeHashLength, _ := cmd.Flags().GetInt("freeze-hash-length")
+			freezeNameTemplate, _ := cmd.Flags().GetString("freeze-name-template")
+			lib, _ := cmd.Flags().GetStringArray("lib")
+			listMerge, _ := cmd.Flags().GetString("list-merge")
+			listMergeKey, _ := cmd.Flags().GetString("list-merge-key")
+			failOnUnknownKeys, _ := cmd.Flags().GetBool("fail-on-unknown-keys")
+			dotEnvInterpolate, _ := cmd.Flags().GetBool("dotenv-interpolate")
+			env, _ := cmd.Flags().GetString("env")
+			opts := render.Options{
+				Syntax:             explicitFormat,
+				Chroot:             chroot,
+				ChrootTemplateDir:  allowFsAccess,
+				Freeze:             freeze,
+				FreezeRefs:         freezeRefs,
+				FreezeList:         normalizedFreezeList,
+				FreezePaths:        freezePaths,
+				FreezePathsFiles:   freezePathsFiles,
+				FreezeHash:         freezeHash,
+				FreezeHashLength:   freezeHashLength,
+				FreezeNameTemplate: freezeNameTemplate,
+				IgnoreUnset:        ignoreUnset,
+				NodeAware:          nodeAware,
+				Lib:                lib,
+				ListMerge:          listMerge,
+				ListMergeKey:       listMergeKey,
+				FailOnUnknownKeys:  failOnUnknownKeys,
+				DotEnvInterpolate:  dotEnvInterpolate,
+			}
+			outputFormat, _ := cmd.Flags().GetString("output-format")
+			output, _ := cmd.Flags().GetString("output")
+			outputDir, _ := cmd.Flags().GetString("output-dir")
+			if output != "" && outputDir != "" {
+				log.Fatalf("-o/--output and --output-dir cannot be used simultaneously")
 			}
-			if output, _ := cmd.Flags().GetString("output"); output != "" && output != "-" {
-				err := ioutil.WriteFile(output, out, 0600)
+			watch, _ := cmd.Flags().GetBool("watch")
+			diffAgainst := cmd.Flags().Changed("diff-against")
+			envVars := render.ReadEnv(os.Environ(), envOptions(cmd.Flags()))
+			var readFile func(path string) ([]byte, error)
+			var readDir func(dir string) ([]os.FileInfo, error)
+			run := func() error {
+				// (package is (re)opened on every run so that --watch picks up changes made to kubetpl.yaml)
+				renderer := render.New(opts)
+				renderer.ReadFile, renderer.ReadDir = readFile, readDir
+				templates, err := renderer.Open(args, env)
 				if err != nil {
-					log.Fatal(err)
+					return err
+				}
+				config, err := readConfig(renderer, configFiles, envVars, configKeyValuePairs)
+				if err != nil {
+					return err
+				}
+				if err := renderer.ValidateConfig(config); err != nil {
+					return err
+				}
+				res, err := renderer.Render(templates, config)
+				if err != nil {
+					return err
+				}
+				if diffAgainst {
+					context, _ := cmd.Flags().GetString("diff-against")
+					kubeconfig, _ := cmd.Flags().GetString("kubeconfig")
+					diff, err := diffAgainstCluster(res, kubeconfig, context)
+					if err != nil {
+						return err
+					}
+					fmt.Print(render.FormatDiff(diff))
+					if len(diff) != 0 && !watch {
+						os.Exit(1)
+					}
+					return nil
+				}
+				if outputDir != "" {
+					namePattern, _ := cmd.Flags().GetString("output-name-pattern")
+					clean, _ := cmd.Flags().GetBool("output-dir-clean")
+					files, err := res.WriteDir(outputDir, render.DirOptions{
+						NamePattern: namePattern,
+						Format:      outputFormat,
+						Clean:       clean,
+					})
+					if err != nil {
+						return err
+					}
+					for _, file := range files {
+						log.Debugf("%s written", file)
+					}
+					return nil
+				}
+				out, err := res.Encode(outputFormat)
+				if err != nil {
+					return err
+				}
+				if output != "" && output != "-" {
+					return ioutil.WriteFile(output, out, 0600)
 				}
-			} else {
 				os.Stdout.Write(out)
-				fmt.Println()
+				if outputFormat == render.FormatYAML {
+					fmt.Println()
+				}
+				return nil
+			}
+			if !watch {
+				if err := run(); err != nil {
+					log.Fatal(err)
+				}
+				return nil
+			}
+			for _, arg := range append(append([]string{}, args...), configFiles...) {
+				if arg == "-" {
+					log.Fatalf("--watch cannot be used with stdin (\"-\")")
+				}
+			}
+			debounce, _ := cmd.Flags().GetDuration("watch-debounce")
+			watcher := &render.Watcher{Debounce: debounce}
+			var files []string
+			// (files are added to the watcher before they are read so that changes made during rendering are not missed)
+			readFile = func(path string) ([]byte, error) {
+				// package manifest, templates, config files, --freeze-ref|s and "kubetpl/data-from-file" entries (even if missing)
+				files = append(files, path)
+				watcher.Add(path)
+				return render.ReadFile(path)
+			}
+			readDir = func(dir string) ([]os.FileInfo, error) {
+				// (modification time of the directory changes whenever file is added/removed)
+				files = append(files, dir)
+				watcher.Add(dir)
+				return ioutil.ReadDir(dir)
+			}
+			var lastRendered []string
+			for {
+				files = nil
+				watcher.Reset()
+				if err := run(); err != nil {
+					log.Error(err)
+					// rendering might have stopped before all the files were read
+					watcher.Keep(lastRendered)
+				} else {
+					lastRendered = files
+					if output != "" || outputDir != "" {
+						log.Infof("Rendered to %s%s", output, outputDir)
+					}
+				}
+				log.Infof("Watching %d file(s) for changes (Ctrl+C to exit)", len(watcher.Files()))
+				changed := watcher.Wait()
+				log.Infof("%s changed", strings.Join(changed, ", "))
 			}
-			return nil
 		},
 		Example: "  kubetpl render template.yml -i staging.env -s KEY=VALUE --syntax=$\n\n" +
 			"  # if template contains \"# kubetpl:syntax:<template flavor, e.g. $>\" --syntax can be omitted (recommended)\n" +
-			"  kubetpl render template.yml -i staging.env -s KEY=VALUE",
+			"  kubetpl render template.yml -i staging.env -s KEY=VALUE\n\n" +
+			"  # all *.{yml,yaml,json} within k8s/ (recursively, except for the ones listed in .kubetplignore)\n" +
+			"  kubetpl render k8s/ -i staging.env\n\n" +
+			"  # glob pattern\n" +
+			"  kubetpl render 'k8s/**/*.yml' -i staging.env\n\n" +
+			"  # package (directory containing kubetpl.yaml)\n" +
+			"  kubetpl render k8s/app --env staging",
 	}
 	renderCmd.Flags().BoolVarP(&freeze, "freeze", "z", false, "Freeze ConfigMap/Secret|s")
 	renderCmd.Flags().BoolVar(&ignoreUnset, "ignore-unset", false, "Keep $VAR/${VAR} if not set (e.g. \"echo 'kind: $A$B' | kubetpl r - -s A=X --syntax=$ --ignore-unset\" prints \"kind: X$B\")")
+	renderCmd.Flags().BoolVar(&nodeAware, "node-aware", false, "Substitute $VAR/${VAR} within YAML values only"+
+		" (values containing \":\", \"#\", newlines, etc. are quoted/escaped as needed)")
 	renderCmd.Flags().StringArrayVar(&freezeRefs, "freeze-ref", nil,
 		"External ConfigMap/Secret|s that should not be included in the output and yet references to which need to be '--freeze'd")
 	renderCmd.Flags().StringSliceVar(&freezeList, "freeze-list", nil,
 		"<kind>/<name>s to freeze (e.g. ConfigMap/foo, Secret/bar)")
+	addFreezePathFlags(renderCmd.Flags())
+	addFreezeNamingFlags(renderCmd.Flags())
 	renderCmd.Flags().StringP("type", "t", "", "Template flavor ($, go-template or template-kind)")
 	renderCmd.Flags().MarkDeprecated("type",
 		"use --syntax=<$|go-template|template-kind> instead\n"+
 			"(if you wish to avoid typing --syntax=... - "+
 			"add \"# kubetpl:syntax:<$, go-template or template-kind>\" comment (preferably at the top of the template))")
-	renderCmd.Flags().StringVarP(&syntax, "syntax", "x", "", "Template flavor ($, go-template or template-kind) (https://github.com/shyiko/kubetpl#template-flavors)")
+	addTemplateFlags(renderCmd.Flags())
 	renderCmd.Flags().BoolP("shorthand-P", "P", false, "")
 	renderCmd.Flags().BoolP("shorthand-G", "G", false, "")
 	renderCmd.Flags().BoolP("shorthand-T", "T", false, "")
@@ -181,15 +305,270 @@ func main() {
 			"(if you wish to avoid typing --syntax=... - "+
 			"add \"# kubetpl:syntax:template-kind\" comment (preferably at the top of the template))")
 	renderCmd.Flags().StringArrayVarP(&configFiles, "input", "i", nil, "Config file(s) (*.{env,yml,yaml,json})")
-	renderCmd.Flags().StringArrayVarP(&configKeyValuePairs, "set", "s", []string{},
-		"<key>=<value> pairs (take precedence over --input files (if any))")
+	addConfigOverrideFlags(renderCmd.Flags(), &configKeyValuePairs, "")
+	addListMergeFlags(renderCmd.Flags())
+	addEnvFlags(renderCmd.Flags())
+	renderCmd.Flags().Bool("fail-on-unknown-keys", false, "Fail if config contains keys that aren't referenced by any of the templates")
 	renderCmd.Flags().StringVarP(&chroot, "chroot", "c", "",
 		"The root directory in which extensions like \"kubetpl/data-from-file\" are to be allowed to read files\n"+
 			"(access to anything outside of --chroot will denied)")
 	renderCmd.Flags().BoolVar(&allowFsAccess, "allow-fs-access", false,
 		`Shorthand for --chroot=<directory containing template>`)
+	addPackageEnvFlag(renderCmd.Flags())
 	renderCmd.Flags().StringP("output", "o", "", "Redirect output to a file")
+	renderCmd.Flags().String("diff-against", "", "Instead of printing rendered objects, show how they differ from"+
+		" the ones in the cluster (<kubeconfig context>, e.g. --diff-against=staging)")
+	renderCmd.Flags().String("kubeconfig", "", "Path to the kubeconfig file (--diff-against)"+
+		" ($KUBECONFIG or ~/.kube/config by default)")
+	renderCmd.Flags().String("output-dir", "", "Write each object to a separate file within a given directory"+
+		" (see --output-name-pattern)")
+	renderCmd.Flags().String("output-name-pattern", "", "--output-dir file name pattern"+
+		" (\""+render.DefaultNamePattern+"\" by default (.json instead of .yaml unless --output-format is yaml))\n"+
+		"(e.g. \"{{metadata.namespace}}/{{kind}}-{{metadata.name}}.yaml\" to group objects by namespace)")
+	renderCmd.Flags().Bool("output-dir-clean", false, "Remove files written to --output-dir by the previous run"+
+		" that are no longer produced")
+	renderCmd.Flags().BoolP("watch", "w", false, "Re-render whenever template(s), config file(s), --freeze-ref|s or"+
+		" \"kubetpl/data-from-file\" entries change")
+	renderCmd.Flags().Duration("watch-debounce", 300*time.Millisecond, "--watch: how long to wait for file(s) to"+
+		" stop changing before re-rendering")
+	renderCmd.Flags().String("output-format", render.FormatYAML, "Output format (yaml, json, json-lines or list"+
+		" (a single JSON-encoded \"kind: List\" object))")
 	rootCmd.AddCommand(renderCmd)
+	varsCmd := &cobra.Command{
+		Use:     "vars [file...]",
+		Aliases: []string{"inspect"},
+		Short:   "List variables referenced by template(s)",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return pflag.ErrHelp
+			}
+			outputFormat, _ := cmd.Flags().GetString("output-format")
+			if outputFormat != "text" && outputFormat != "json" {
+				log.Fatalf(`--output-format must be either "text" or "json" (got "%s")`, outputFormat)
+			}
+			syntax, _ := cmd.Flags().GetString("syntax")
+			lib, _ := cmd.Flags().GetStringArray("lib")
+			renderer, args, err := render.OpenPackage(args, "", render.Options{Syntax: syntax, Lib: lib})
+			if err != nil {
+				log.Fatal(err)
+			}
+			vars, err := renderer.Vars(args)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if outputFormat == "json" {
+				err = printVarsJSON(os.Stdout, vars)
+			} else {
+				err = printVars(os.Stdout, vars)
+			}
+			if err != nil {
+				log.Fatal(err)
+			}
+			return nil
+		},
+		Example: "  kubetpl vars template.yml\n\n" +
+			"  kubetpl vars template.yml --output-format=json",
+	}
+	addTemplateFlags(varsCmd.Flags())
+	varsCmd.Flags().String("output-format", "text", "Output format (text or json)")
+	rootCmd.AddCommand(varsCmd)
+	var checkConfigFiles []string
+	var checkConfigKeyValuePairs []configOverride
+	checkCmd := &cobra.Command{
+		Use:   "check [file...]",
+		Short: "Check that config provides all the variables template(s) need (without rendering anything)",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return pflag.ErrHelp
+			}
+			env, _ := cmd.Flags().GetString("env")
+			syntax, _ := cmd.Flags().GetString("syntax")
+			lib, _ := cmd.Flags().GetStringArray("lib")
+			listMerge, _ := cmd.Flags().GetString("list-merge")
+			listMergeKey, _ := cmd.Flags().GetString("list-merge-key")
+			dotEnvInterpolate, _ := cmd.Flags().GetBool("dotenv-interpolate")
+			renderer, args, err := render.OpenPackage(args, env, render.Options{Syntax: syntax, Lib: lib,
+				ListMerge: listMerge, ListMergeKey: listMergeKey, DotEnvInterpolate: dotEnvInterpolate})
+			if err != nil {
+				log.Fatal(err)
+			}
+			envVars := render.ReadEnv(os.Environ(), envOptions(cmd.Flags()))
+			config, err := readConfig(renderer, checkConfigFiles, envVars, checkConfigKeyValuePairs)
+			if err != nil {
+				log.Fatal(err)
+			}
+			report, err := renderer.Check(args, config)
+			if err != nil {
+				log.Fatal(err)
+			}
+			failOnUnknownKeys, _ := cmd.Flags().GetBool("fail-on-unknown-keys")
+			if outputFormat, _ := cmd.Flags().GetString("output-format"); outputFormat == "json" {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				if err := enc.Encode(report); err != nil {
+					log.Fatal(err)
+				}
+			} else {
+				for _, p := range report.Missing {
+					log.Error(p)
+				}
+				for _, p := range report.Invalid {
+					log.Error(p)
+				}
+				for _, p := range report.Unused {
+					if failOnUnknownKeys {
+						log.Error(p)
+					} else {
+						log.Warn(p)
+					}
+				}
+			}
+			if !report.OK() || (failOnUnknownKeys && len(report.Unused) != 0) {
+				os.Exit(1)
+			}
+			// (unset/invalid vars referenced by the templates have already been reported above)
+			if err := renderer.ValidateConfig(config); err != nil {
+				log.Fatal(err)
+			}
+			return nil
+		},
+		Example: "  kubetpl check template.yml -i staging.env -s KEY=VALUE\n\n" +
+			"  # package (directory containing kubetpl.yaml)\n" +
+			"  kubetpl check k8s/app --env staging",
+	}
+	addTemplateFlags(checkCmd.Flags())
+	checkCmd.Flags().StringArrayVarP(&checkConfigFiles, "input", "i", nil, "Config file(s) (*.{env,yml,yaml,json})")
+	addConfigOverrideFlags(checkCmd.Flags(), &checkConfigKeyValuePairs, "")
+	addListMergeFlags(checkCmd.Flags())
+	addEnvFlags(checkCmd.Flags())
+	checkCmd.Flags().Bool("fail-on-unknown-keys", false, "Treat config keys that aren't referenced by any of the templates as errors")
+	checkCmd.Flags().String("output-format", "text", "Output format (text or json)")
+	addPackageEnvFlag(checkCmd.Flags())
+	rootCmd.AddCommand(checkCmd)
+	var diffConfigFiles, diffToConfigFiles []string
+	var diffConfigKeyValuePairs, diffToConfigKeyValuePairs []configOverride
+	diffCmd := &cobra.Command{
+		Use:   "diff [file...] [-- file...]",
+		Short: "Show (semantic, per-object) difference between two renders",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return pflag.ErrHelp
+			}
+			from, to := args, args
+			if dash := cmd.ArgsLenAtDash(); dash != -1 {
+				from, to = args[:dash], args[dash:]
+			}
+			env, _ := cmd.Flags().GetString("env")
+			toEnv, _ := cmd.Flags().GetString("to-env")
+			if toEnv == "" {
+				toEnv = env
+			}
+			syntax, _ := cmd.Flags().GetString("syntax")
+			freeze, _ := cmd.Flags().GetBool("freeze")
+			freezePaths, _ := cmd.Flags().GetStringArray("freeze-path")
+			freezePathsFiles, _ := cmd.Flags().GetStringArray("freeze-paths-file")
+			freezeHash, _ := cmd.Flags().GetString("freeze-hash")
+			freezeHashLength, _ := cmd.Flags().GetInt("freeze-hash-length")
+			freezeNameTemplate, _ := cmd.Flags().GetString("freeze-name-template")
+			chroot, _ := cmd.Flags().GetString("chroot")
+			allowFsAccess, _ := cmd.Flags().GetBool("allow-fs-access")
+			lib, _ := cmd.Flags().GetStringArray("lib")
+			listMerge, _ := cmd.Flags().GetString("list-merge")
+			listMergeKey, _ := cmd.Flags().GetString("list-merge-key")
+			failOnUnknownKeys, _ := cmd.Flags().GetBool("fail-on-unknown-keys")
+			dotEnvInterpolate, _ := cmd.Flags().GetBool("dotenv-interpolate")
+			opts := render.Options{
+				Syntax:             syntax,
+				Freeze:             freeze,
+				FreezePaths:        freezePaths,
+				FreezePathsFiles:   freezePathsFiles,
+				FreezeHash:         freezeHash,
+				FreezeHashLength:   freezeHashLength,
+				FreezeNameTemplate: freezeNameTemplate,
+				Chroot:             chroot,
+				ChrootTemplateDir:  allowFsAccess,
+				Lib:                lib,
+				ListMerge:          listMerge,
+				ListMergeKey:       listMergeKey,
+				FailOnUnknownKeys:  failOnUnknownKeys,
+				DotEnvInterpolate:  dotEnvInterpolate,
+			}
+			toConfigFiles, toConfigKeyValuePairs := diffConfigFiles, diffConfigKeyValuePairs
+			if len(diffToConfigFiles) != 0 || len(diffToConfigKeyValuePairs) != 0 {
+				toConfigFiles, toConfigKeyValuePairs = diffToConfigFiles, diffToConfigKeyValuePairs
+			}
+			envVars := render.ReadEnv(os.Environ(), envOptions(cmd.Flags()))
+			renderPackage := func(args []string, env string,
+				configFiles []string, configKeyValuePairs []configOverride) (*render.Result, error) {
+				r, templates, err := render.OpenPackage(args, env, opts)
+				if err != nil {
+					return nil, err
+				}
+				config, err := readConfig(r, configFiles, envVars, configKeyValuePairs)
+				if err != nil {
+					return nil, err
+				}
+				if err := r.ValidateConfig(config); err != nil {
+					return nil, err
+				}
+				return r.Render(templates, config)
+			}
+			fromResult, err := renderPackage(from, env, diffConfigFiles, diffConfigKeyValuePairs)
+			if err != nil {
+				log.Fatal(err)
+			}
+			toResult, err := renderPackage(to, toEnv, toConfigFiles, toConfigKeyValuePairs)
+			if err != nil {
+				log.Fatal(err)
+			}
+			diff, err := render.Diff(fromResult.Objects(), toResult.Objects())
+			if err != nil {
+				log.Fatal(err)
+			}
+			if outputFormat, _ := cmd.Flags().GetString("output-format"); outputFormat == "json" {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetEscapeHTML(false)
+				enc.SetIndent("", "  ")
+				if diff == nil {
+					diff = []render.ObjectDiff{}
+				}
+				if err := enc.Encode(diff); err != nil {
+					log.Fatal(err)
+				}
+			} else {
+				fmt.Print(render.FormatDiff(diff))
+			}
+			if len(diff) != 0 {
+				os.Exit(1)
+			}
+			return nil
+		},
+		Example: "  # same template(s), different config\n" +
+			"  kubetpl diff template.yml -i staging.env --to-input prod.env\n\n" +
+			"  # different template(s) (e.g. two revisions), same config\n" +
+			"  kubetpl diff -i staging.env old/template.yml -- new/template.yml\n\n" +
+			"  # package (directory containing kubetpl.yaml), staging vs prod\n" +
+			"  kubetpl diff k8s/app --env staging --to-env prod",
+	}
+	addTemplateFlags(diffCmd.Flags())
+	diffCmd.Flags().StringArrayVarP(&diffConfigFiles, "input", "i", nil, "Config file(s) (*.{env,yml,yaml,json})")
+	addConfigOverrideFlags(diffCmd.Flags(), &diffConfigKeyValuePairs, "")
+	diffCmd.Flags().StringArrayVar(&diffToConfigFiles, "to-input", nil,
+		"Config file(s) to compare against (--input/--set are used if neither --to-input nor --to-set is specified)")
+	addConfigOverrideFlags(diffCmd.Flags(), &diffToConfigKeyValuePairs, "to-")
+	addListMergeFlags(diffCmd.Flags())
+	addEnvFlags(diffCmd.Flags())
+	diffCmd.Flags().Bool("fail-on-unknown-keys", false, "Fail if config contains keys that aren't referenced by any of the templates")
+	addPackageEnvFlag(diffCmd.Flags())
+	diffCmd.Flags().String("to-env", "", "Environment to compare against (--env is used if not specified)")
+	diffCmd.Flags().BoolP("freeze", "z", false, "Freeze ConfigMap/Secret|s")
+	addFreezePathFlags(diffCmd.Flags())
+	addFreezeNamingFlags(diffCmd.Flags())
+	diffCmd.Flags().StringP("chroot", "c", "",
+		"The root directory in which extensions like \"kubetpl/data-from-file\" are to be allowed to read files")
+	diffCmd.Flags().Bool("allow-fs-access", false, `Shorthand for --chroot=<directory containing template>`)
+	diffCmd.Flags().String("output-format", "text", "Output format (text or json)")
+	rootCmd.AddCommand(diffCmd)
 	completionCmd := &cobra.Command{
 		Use:   "completion",
 		Short: "Command-line completion",
@@ -236,354 +615,230 @@ func main() {
 	}
 }
 
-func normalizeRef(v string) (string, error) {
-	split := strings.SplitN(v, "/", 2)
-	if len(split) != 2 {
-		return "", fmt.Errorf(`"%s" is not a valid resource reference (expected <kind>/<name>, e.g. ConfigMap/app)`, v)
-	}
-	kind, name := strings.ToLower(split[0]), split[1]
-	switch kind {
-	case "cm", "configmap", "configmaps":
-		kind = "ConfigMap"
-	case "secret", "secrets":
-		kind = "Secret"
-	default:
-		return "", fmt.Errorf(`Invalid kind "%s" (valid (case-insensitive): cm/configmaps/configmap, secrets/secret)`, v)
-	}
-	return kind + "/" + name, nil
+// configOverride is a -s/--set, --set-string or --set-json <key>=<value> pair.
+type configOverride struct {
+	typ  string // "set", "set-string" or "set-json"
+	pair string
 }
 
-func walk(cmd *cobra.Command, cb func(*cobra.Command)) {
-	cb(cmd)
-	for _, c := range cmd.Commands() {
-		walk(c, cb)
-	}
+// configOverrideValue collects --set* flags (into a single slice to preserve the order they are given in).
+type configOverrideValue struct {
+	overrides *[]configOverride
+	typ       string
 }
 
-type renderOpts struct {
-	format            string
-	chroot            string
-	chrootTemplateDir bool
-	freeze            bool
-	freezeRefs        []string
-	freezeList        []string
-	ignoreUnset       bool
+func (v *configOverrideValue) Set(s string) error {
+	*v.overrides = append(*v.overrides, configOverride{v.typ, s})
+	return nil
 }
 
-func render(templateFiles []string, data map[string]interface{}, opts renderOpts) ([]byte, error) {
-	objs, err := renderTemplates(templateFiles, data, opts)
-	if err != nil {
-		return nil, err
-	}
-	if opts.freeze || len(opts.freezeRefs) > 0 || len(opts.freezeList) > 0 {
-		refs, err := renderTemplates(opts.freezeRefs, data, opts)
-		if err != nil {
-			return nil, err
-		}
-		if err := processor.FreezeInPlace(processor.FreezeRequest{
-			Docs:    bodySlice(objs),
-			Refs:    bodySlice(refs),
-			Include: opts.freezeList,
-		}); err != nil {
-			return nil, err
-		}
-	}
-	var buf bytes.Buffer
-	for _, obj := range objs {
-		if len(obj.body) == 0 {
-			continue
-		}
-		o, err := yaml.Marshal(obj.body)
-		if err != nil {
-			return nil, err
-		}
-		buf.Write([]byte("---\n"))
-		buf.Write(o)
-		if len(obj.footer) > 0 {
-			// support for comment-less meta is planned in kubesec@1.0.0
-			// until then "# kubesec:" footer is preserved to allow freezing
-			for i, line := range bytes.Split(obj.footer, []byte("\n")) {
-				if bytes.HasPrefix(line, []byte("# kubesec:")) {
-					if i > 0 {
-						buf.Write([]byte("\n"))
-					}
-					buf.Write(line)
-				}
-			}
-		}
-	}
-	return buf.Bytes(), nil
+func (v *configOverrideValue) Type() string { return "stringArray" }
+
+func (v *configOverrideValue) String() string { return "" }
+
+// addConfigOverrideFlags registers -s/--set, --set-string and --set-json (or --<prefix>set, etc if prefix is not empty).
+func addConfigOverrideFlags(flags *pflag.FlagSet, overrides *[]configOverride, prefix string) {
+	shorthand, usage := "s", "(take precedence over --input files (if any))"
+	if prefix != "" {
+		shorthand, usage = "", "to compare against (take precedence over --"+prefix+"input files (if any))"
+	}
+	flags.VarP(&configOverrideValue{overrides, "set"}, prefix+"set", shorthand,
+		"<key>=<value> pairs "+usage+"\n"+
+			"(<key> can be a path (e.g. a.b[0].c), <value> is a string (use --"+prefix+"set-json for bool/int/list/map))")
+	flags.Var(&configOverrideValue{overrides, "set-string"}, prefix+"set-string",
+		"Same as --"+prefix+"set (<value> is a string)")
+	flags.Var(&configOverrideValue{overrides, "set-json"}, prefix+"set-json",
+		"Same as --"+prefix+"set except that <value> is JSON (e.g. --"+prefix+"set-json 'hosts=[\"a\",\"b\"]')")
 }
 
-type document struct {
-	header []byte
-	body   map[interface{}]interface{}
-	footer []byte
+func addEnvFlags(flags *pflag.FlagSet) {
+	flags.StringArray("env-prefix", nil, "Import environment variables starting with <prefix> (e.g. APP_)"+
+		" (prefix is stripped unless --env-keep-prefix is set)\n"+
+		"(take precedence over --input files and are overridden by --set)")
+	flags.Bool("env-keep-prefix", false, "Keep --env-prefix (APP_DB_HOST is imported as DB_HOST otherwise)")
+	flags.StringSlice("from-env", nil, "Import given environment variables (e.g. --from-env=DB_PASSWORD,API_TOKEN)"+
+		" (take precedence over --env-prefix)")
+	flags.Bool("dotenv-interpolate", false, "Expand ${KEY}/$KEY references within *.env files"+
+		" (-i and \"kubetpl/data-from-env-file\")\n(KEYs are resolved against variables defined earlier in the same file"+
+		" and then environment variables)")
 }
 
-func bodySlice(docs []document) []map[interface{}]interface{} {
-	var r []map[interface{}]interface{}
-	for _, doc := range docs {
-		r = append(r, doc.body)
-	}
-	return r
+func envOptions(flags *pflag.FlagSet) render.EnvOptions {
+	prefixes, _ := flags.GetStringArray("env-prefix")
+	keepPrefix, _ := flags.GetBool("env-keep-prefix")
+	names, _ := flags.GetStringSlice("from-env")
+	return render.EnvOptions{Prefixes: prefixes, KeepPrefix: keepPrefix, Names: names}
 }
 
-func renderTemplates(templateFiles []string, config map[string]interface{}, opts renderOpts) ([]document, error) {
-	chroot := opts.chroot
-	if chroot != "" {
-		var err error
-		chroot, err = filepath.Abs(chroot)
-		if err != nil {
-			return nil, err
-		}
-	}
-	localOpts := opts // copy
-	localOpts.chroot = chroot
-	var objs []document
-	for _, templateFile := range templateFiles {
-		docs, err := renderTemplate(templateFile, config, localOpts)
-		if err != nil {
-			return nil, err
-		}
-		objs = append(objs, docs...)
-	}
-	return objs, nil
+// addTemplateFlags registers --syntax and --lib.
+func addTemplateFlags(flags *pflag.FlagSet) {
+	flags.StringP("syntax", "x", "", "Template flavor ($, go-template or template-kind) (https://github.com/shyiko/kubetpl#template-flavors)")
+	flags.StringArray("lib", nil, "Directory with partials (\"# kubetpl:include:<path>\" is resolved against it (after the directory of the template),\n"+
+		"go-template can reference partials with {{ template \"<path>\" . }} / {{ include \"<path>\" . }});\n"+
+		"only .yml/.yaml/.json/.kubetpl/.kubetpl-go files are loaded; does not require --allow-fs-access")
 }
 
-func renderTemplate(templateFile string, config map[string]interface{}, opts renderOpts) ([]document, error) {
-	t, directives, err := newTemplate(templateFile, opts.format, opts.ignoreUnset)
-	if err != nil {
-		return nil, err
-	}
-	data := make(map[string]interface{})
-	for _, d := range directives {
-		if d.Key == directiveSet {
-			split := strings.SplitN(d.Value, "=", 2)
-			data[split[0]] = split[1]
-		}
-	}
-	for k, v := range config {
-		data[k] = v
-	}
-	out, err := t.Render(data)
-	if err != nil {
-		return nil, err
-	}
-	baseDir, err := dirnameAbs(templateFile)
-	if err != nil {
-		return nil, err
-	}
-	templateChroot := opts.chroot
-	if opts.chroot == "" && opts.chrootTemplateDir {
-		templateChroot = baseDir
-	}
-	if templateChroot != "" && !strings.HasSuffix(templateChroot, string(filepath.Separator)) {
-		templateChroot += string(filepath.Separator)
-	}
-	var objs []document
-	for _, chunk := range yamlext.Chunk(out) {
-		obj := make(map[interface{}]interface{})
-		if err = yaml.Unmarshal(chunk, &obj); err != nil {
-			return nil, err
-		}
-		if _, err := processor.ReplaceDataFromFileInPlace(obj, func(path string) (string, []byte, error) {
-			file := path
-			if !filepath.IsAbs(file) {
-				file = filepath.Join(baseDir, file)
-			}
-			file, err := filepath.Abs(file)
-			if err != nil {
-				return "", nil, err
-			}
-			if templateChroot == "" || !strings.HasPrefix(file, templateChroot) {
-				fileRel := file
-				if cwd, err := os.Getwd(); err == nil {
-					if p, err := filepath.Rel(cwd, file); err == nil {
-						fileRel = p
-					}
-				}
-				return "", nil, fmt.Errorf(`%s: access denied: %s`+
-					" (use --allow-fs-access and/or -c/--chroot=<root dir, e.g. '.'> to allow)",
-					templateFile, fileRel)
-			}
-			data, err := ioutil.ReadFile(file)
-			return filepath.Base(file), data, err
-		}); err != nil {
-			return nil, err
-		}
-		objs = append(objs, document{
-			header: yamlext.Header(chunk),
-			body:   obj,
-			footer: yamlext.Footer(chunk),
-		})
-	}
-	return objs, nil
+func addPackageEnvFlag(flags *pflag.FlagSet) {
+	flags.String("env", "", "Environment (package only (directory containing kubetpl.yaml)): config file(s) listed under envs.<env> in kubetpl.yaml"+
+		" (envs/<env>.{env,yml,yaml,json} by default) are applied on top of defaults.yaml (and before -i/-s)")
 }
 
-func dirnameAbs(path string) (string, error) {
-	if path == "-" {
-		return os.Getwd()
-	}
-	return filepath.Abs(filepath.Dir(path))
+func addFreezePathFlags(flags *pflag.FlagSet) {
+	flags.StringArray("freeze-path", nil,
+		"Additional place ConfigMap/Secret can be referenced from (--freeze), <kind>[.<group>]:<path>[:<ConfigMap|Secret>]\n"+
+			"(e.g. 'Rollout.argoproj.io:spec.template.spec.containers[*].envFrom[*].configMapRef.name')")
+	flags.StringArray("freeze-paths-file", nil,
+		"YAML file with --freeze-path|s ({ConfigMap: {<kind>[.<group>]: [<path>, ...]}, Secret: {<kind>[.<group>]: [<path>, ...]}})")
 }
 
-func newTemplate(file string, flavor string, ignoreUnset bool) (engine.Template, []directive, error) {
-	content, err := readFile(file)
-	if err != nil {
-		return nil, nil, err
-	}
-	content = bytes.Replace(content, []byte("\r\n"), []byte("\n"), -1)
-	directives, err := parseDirectives(content)
-	if err != nil {
-		return nil, nil, fmt.Errorf("%s: %s", file, err.Error())
-	}
-	for _, d := range directives {
-		if d.Key == directiveSyntax {
-			flavor = d.Value
-		}
-	}
-	if flavor == "" {
-		if hasExtensionAny(file, ".yml.kubetpl", ".yaml.kubetpl", ".json.kubetpl-go") {
-			log.Warnf("*.{yml,yaml,json}.kubetpl as an indicator for \"$\" flavor has been deprecated" +
-				" (please use `--syntax=$` or `# kubetpl:syntax:$` directive instead)")
-			flavor = "$"
-		}
-		if hasExtensionAny(file, ".yml.kubetpl-go", ".yaml.kubetpl-go", ".json.kubetpl-go") {
-			log.Warnf("*.{yml,yaml,json}.kubetpl-go as an indicator for \"go-template\" flavor has been deprecated" +
-				" (please use `--syntax=go-template` or `# kubetpl:syntax:go-template` directive instead)")
-			flavor = "go-template"
-		}
-	}
-	var t engine.Template
-	switch flavor {
-	case "$":
-		var opts []engine.ShellTemplateOption
-		if ignoreUnset {
-			opts = append(opts, engine.ShellTemplateIgnoreUnset())
-		}
-		t, err = engine.NewShellTemplate(content, opts...)
-	case "go-template":
-		t, err = engine.NewGoTemplate(content, file)
-	case "template-kind":
-		t, err = engine.NewTemplateKindTemplate(content, engine.TemplateKindTemplateDropNull())
-	default:
-		if flavor != "" {
-			return nil, nil, fmt.Errorf("%s: unknown template type \"%s\" "+
-				"(expected \"$\", \"go-template\" or \"template-kind\")", file, flavor)
-		}
-		// warn if "kind: Template" is present
-		for _, chunk := range yamlext.Chunk(content) {
-			m := make(map[interface{}]interface{})
-			if err = yaml.Unmarshal(chunk, &m); err != nil {
-				return nil, nil, fmt.Errorf("%s does not appear to be a valid YAML (%s).\n"+
-					"Did you forget to specify `--syntax=<$|go-template|template-kind>`"+
-					" / add \"# kubetpl:syntax:<$|go-template|template-kind>\"?", file, err.Error())
-			}
-			if m["kind"] == "Template" {
-				log.Warnf("%s is missing \"# kubetpl:syntax:template-kind\""+
-					" (use `--syntax=template-kind` if you can't add \"# kubetpl:syntax:template-kind\" to the template)", file)
-				break
-			}
-		}
-		t, err = engine.NewTemplateKindTemplate(content, engine.TemplateKindTemplateDropNull()) // change to simple pass-through in 1.0.0
-	}
-	return t, directives, err
+// addFreezeNamingFlags registers --freeze-hash, --freeze-hash-length and --freeze-name-template.
+func addFreezeNamingFlags(flags *pflag.FlagSet) {
+	flags.String("freeze-hash", "object",
+		"What frozen ConfigMap/Secret hash is computed from: object (default), data (data/binaryData/stringData only,\n"+
+			"i.e. labels/annotations are ignored) or kustomize (same hash kustomize's configMapGenerator/secretGenerator would produce)")
+	flags.Int("freeze-hash-length", 0,
+		"Number of characters of the hash to keep (7 by default, kustomize hash is always 10)")
+	flags.String("freeze-name-template", "{{name}}-{{hash}}",
+		"Name of the frozen ConfigMap/Secret ({{name}} - original name, {{hash}} - hash)")
 }
 
-func readConfigFiles(path ...string) (map[string]interface{}, error) {
-	config := make(map[string]interface{})
-	for _, path := range path {
-		cfg, err := readConfigFile(path)
-		if err != nil {
-			return nil, err
-		}
-		for key, value := range cfg {
-			config[key] = value
-		}
-	}
-	return config, nil
+func addListMergeFlags(flags *pflag.FlagSet) {
+	flags.String("list-merge", render.ListMergeReplace, "How lists present in more than one --input file are merged"+
+		" (replace, append or merge-by-key) (maps are always merged recursively)")
+	flags.String("list-merge-key", render.DefaultListMergeKey, "Key list items are matched by (--list-merge=merge-by-key only)")
 }
 
-func readConfigFile(path string) (map[string]interface{}, error) {
-	data, err := readFile(path)
-	if err != nil {
-		return nil, err
-	}
-	if hasExtension(path, ".env") {
-		return parseDotEnv(data)
-	}
-	return parseYAML(data)
+// readConfig reads package defaults and env config file(s) (if any), configFiles, envVars and
+// configKeyValuePairs (in that order).
+func readConfig(r *render.Renderer, configFiles []string, envVars map[string]interface{},
+	configKeyValuePairs []configOverride) (map[string]interface{}, error) {
+	return r.ReadConfig(configFiles, envVars, func(config map[string]interface{}) error {
+		return applyConfigOverrides(config, configKeyValuePairs)
+	})
 }
 
-func hasExtensionAny(path string, ext ...string) bool {
-	for _, suffix := range ext {
-		if hasExtension(path, suffix) {
-			return true
+func applyConfigOverrides(config map[string]interface{}, configKeyValuePairs []configOverride) error {
+	for _, o := range configKeyValuePairs {
+		split := strings.SplitN(o.pair, "=", 2)
+		if len(split) != 2 {
+			return fmt.Errorf("Expected <key>=<value> pair, instead got %#v (--%s)", o.pair, o.typ)
+		}
+		var value interface{}
+		switch o.typ {
+		case "set-json":
+			if !json.Valid([]byte(split[1])) {
+				return fmt.Errorf("--%s %s: value is not a valid JSON", o.typ, o.pair)
+			}
+			// (JSON is a subset of YAML, which gives us the same types *.{yml,yaml,json} config files produce)
+			if err := yaml.Unmarshal([]byte(split[1]), &value); err != nil {
+				return fmt.Errorf("--%s %s: %s", o.typ, o.pair, err.Error())
+			}
+		default:
+			// (values are never converted (-s A=1 is a string, same as A=1 in *.env), use --set-json for that)
+			value = split[1]
+		}
+		if err := render.SetValue(config, split[0], value); err != nil {
+			return fmt.Errorf("--%s %s: %s", o.typ, o.pair, err.Error())
 		}
 	}
-	return false
+	return nil
 }
 
-func hasExtension(path string, ext string) bool {
-	if strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
-		path = strings.SplitN(path, "#", 2)[0]
-		path = strings.SplitN(path, "?", 2)[0]
+// diffAgainstCluster compares rendered objects with their live versions
+// (objects that do not exist in the cluster are reported as added).
+func diffAgainstCluster(res *render.Result, kubeconfig string, context string) ([]render.ObjectDiff, error) {
+	paths := kube.DefaultConfigPaths()
+	if kubeconfig != "" {
+		paths = []string{kubeconfig}
+	}
+	config, err := kube.LoadConfig(paths...)
+	if err != nil {
+		return nil, err
 	}
-	return strings.HasSuffix(path, ext)
-}
-
-func readFile(path string) ([]byte, error) {
-	if path == "-" {
-		return ioutil.ReadAll(os.Stdin)
+	client, err := kube.NewClient(config, context)
+	if err != nil {
+		return nil, err
 	}
-	if strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
-		res, err := http.Get(path)
+	var live []map[interface{}]interface{}
+	for _, obj := range res.Objects() {
+		l, err := client.Get(obj)
 		if err != nil {
 			return nil, err
 		}
-		defer res.Body.Close()
-		if res.StatusCode != 200 {
-			return nil, fmt.Errorf(`GET "%s" %d`, path, res.StatusCode)
+		if l != nil {
+			live = append(live, kube.Normalize(obj, l))
 		}
-		return ioutil.ReadAll(res.Body)
 	}
-	return ioutil.ReadFile(path)
-}
-
-func parseYAML(data []byte) (map[string]interface{}, error) {
-	m := make(map[string]interface{})
-	yaml.Unmarshal(data, &m)
-	return m, nil
+	return render.Diff(live, res.Objects())
 }
 
-func parseDotEnv(data []byte) (map[string]interface{}, error) {
-	env, err := dotenv.Parse(data)
-	if err != nil {
-		return nil, err
+func normalizeRef(v string) (string, error) {
+	split := strings.SplitN(v, "/", 2)
+	if len(split) != 2 {
+		return "", fmt.Errorf(`"%s" is not a valid resource reference (expected <kind>/<name>, e.g. ConfigMap/app)`, v)
 	}
-	m := make(map[string]interface{})
-	for key, value := range env {
-		m[key] = value
+	kind, name := strings.ToLower(split[0]), split[1]
+	switch kind {
+	case "cm", "configmap", "configmaps":
+		kind = "ConfigMap"
+	case "secret", "secrets":
+		kind = "Secret"
+	default:
+		return "", fmt.Errorf(`Invalid kind "%s" (valid (case-insensitive): cm/configmaps/configmap, secrets/secret)`, v)
 	}
-	return m, nil
+	return kind + "/" + name, nil
 }
 
-type directive struct {
-	Key, Value string
+func walk(cmd *cobra.Command, cb func(*cobra.Command)) {
+	cb(cmd)
+	for _, c := range cmd.Commands() {
+		walk(c, cb)
+	}
 }
 
-func parseDirectives(s []byte) ([]directive, error) {
-	var d []directive
-	for _, line := range strings.Split(string(s), "\n") {
-		if strings.HasPrefix(line, "# kubetpl:") {
-			split := append(strings.SplitN(line[strings.Index(line, ":")+1:], ":", 2), "")
-			key, value := split[0], split[1]
-			if key != directiveSyntax && key != directiveSet {
-				return nil, fmt.Errorf("unrecognized # kubetpl:%s directive", key)
-			}
-			d = append(d, directive{key, value})
+func printVars(w io.Writer, templates []render.TemplateVars) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for i, t := range templates {
+		if i > 0 {
+			fmt.Fprintln(tw)
 		}
+		if t.Syntax != "" {
+			fmt.Fprintf(tw, "%s (syntax: %s)\n", t.Source, t.Syntax)
+		} else {
+			fmt.Fprintf(tw, "%s\n", t.Source)
+		}
+		for _, v := range t.Vars {
+			var status string
+			switch {
+			case v.Required && v.Default != nil:
+				// (some of the usages have a default while others don't)
+				status = fmt.Sprintf("required (default: %v)", v.Default)
+			case v.Default != nil:
+				status = fmt.Sprintf("default: %v", v.Default)
+			case v.Required:
+				status = "required"
+			default:
+				status = "optional"
+			}
+			var usages []string
+			for _, u := range v.Usages {
+				usage := u.String()
+				if u.Optional {
+					usage += "?"
+				}
+				usages = append(usages, usage)
+			}
+			fmt.Fprintf(tw, "  %s\t%s\t%s\n", v.Name, status, strings.Join(usages, " "))
+		}
+	}
+	return tw.Flush()
+}
+
+func printVarsJSON(w io.Writer, templates []render.TemplateVars) error {
+	if templates == nil {
+		templates = []render.TemplateVars{}
 	}
-	return d, nil
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(templates)
 }
@@ -0,0 +1,107 @@
+package render
+
+import (
+	"fmt"
+	"github.com/shyiko/kubetpl/engine"
+	"sort"
+)
+
+// Problem is a single issue found by Renderer.Check.
+type Problem struct {
+	Source  string         `json:"template,omitempty"`
+	Name    string         `json:"name"`
+	Message string         `json:"message"`
+	Usages  []engine.Usage `json:"usages,omitempty"`
+}
+
+func (p Problem) String() string {
+	var prefix string
+	if p.Source != "" {
+		prefix = p.Source + ": "
+	}
+	var positions string
+	for i, u := range p.Usages {
+		if i == 0 {
+			positions = " ("
+		} else {
+			positions += ", "
+		}
+		positions += u.String()
+		if i == len(p.Usages)-1 {
+			positions += ")"
+		}
+	}
+	return prefix + p.Message + positions
+}
+
+// CheckReport is a result of Renderer.Check.
+type CheckReport struct {
+	Missing []Problem `json:"missing"`
+	Unused  []Problem `json:"unused"`
+	Invalid []Problem `json:"invalid"`
+}
+
+// OK returns true if none of the templates is going to fail due to missing/invalid variables.
+func (r *CheckReport) OK() bool {
+	return len(r.Missing) == 0 && len(r.Invalid) == 0
+}
+
+// Check validates config against variables referenced by templateFiles (without rendering anything).
+func (r *Renderer) Check(templateFiles []string, config map[string]interface{}) (*CheckReport, error) {
+	templates, err := r.Vars(templateFiles)
+	if err != nil {
+		return nil, err
+	}
+	report := &CheckReport{Missing: []Problem{}, Unused: []Problem{}, Invalid: []Problem{}}
+	used := make(map[string]bool)
+	for _, t := range templates {
+		for _, v := range t.Vars {
+			used[engine.PathRoot(v.Name)] = true
+			value, ok := engine.Lookup(config, v.Name)
+			if !ok || value == nil {
+				if v.Required {
+					report.Missing = append(report.Missing, Problem{
+						Source:  t.Source,
+						Name:    v.Name,
+						Message: fmt.Sprintf("\"%s\" isn't set", v.Name),
+						// (usages with a default/guard are not going to fail)
+						Usages: requiredUsages(v.Usages),
+					})
+				}
+				value = v.Default
+			}
+			if err := engine.ValidateParameterType(v.Name, v.Type, value); err != nil {
+				report.Invalid = append(report.Invalid, Problem{
+					Source:  t.Source,
+					Name:    v.Name,
+					Message: err.Error(),
+					Usages:  v.Usages,
+				})
+			}
+		}
+	}
+	var keys []string
+	for key := range config {
+		if !used[key] && !r.implicitKeys[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		report.Unused = append(report.Unused, Problem{
+			Name:    key,
+			Message: fmt.Sprintf("\"%s\" is not referenced by any of the templates", key),
+		})
+	}
+	return report, nil
+}
+
+func requiredUsages(usages []engine.Usage) []engine.Usage {
+	var r []engine.Usage
+	for _, u := range usages {
+		if !u.Optional {
+			r = append(r, u)
+		}
+	}
+	return r
+}
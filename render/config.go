@@ -0,0 +1,176 @@
+package render
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/shyiko/kubetpl/dotenv"
+	yamlext "github.com/shyiko/kubetpl/yaml"
+	"gopkg.in/yaml.v2"
+	yamlv3 "gopkg.in/yaml.v3"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ReadConfigFiles reads *.{env,yml,yaml,json} config files (in order, with latter taking precedence over former).
+// Nested maps are merged recursively (lists - according to the Options.ListMerge).
+func (r *Renderer) ReadConfigFiles(path ...string) (map[string]interface{}, error) {
+	config := make(map[string]interface{})
+	for _, path := range path {
+		cfg, err := r.readConfigFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := r.MergeConfig(config, cfg); err != nil {
+			return nil, err
+		}
+	}
+	return config, nil
+}
+
+func (r *Renderer) readConfigFile(path string) (map[string]interface{}, error) {
+	if path != "-" && !isURL(path) {
+		if abs, err := filepath.Abs(path); err == nil {
+			if r.configFiles == nil {
+				r.configFiles = make(map[string]bool)
+			}
+			r.configFiles[abs] = true
+		}
+	}
+	data, err := r.readFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if hasExtension(path, ".env") {
+		return parseDotEnv(path, data, r.dotEnvOptions()...)
+	}
+	return parseYAML(path, data)
+}
+
+var yamlErrorLine = regexp.MustCompile(`^(?:yaml: )?line (\d+): `)
+
+// configError turns "yaml: line N: <message>" into "<file>:N: <message>".
+func configError(file string, err error) error {
+	msg := err.Error()
+	if m := yamlErrorLine.FindStringSubmatch(msg); m != nil {
+		return fmt.Errorf("%s:%s: %s", file, m[1], msg[len(m[0]):])
+	}
+	return fmt.Errorf("%s: %s", file, strings.TrimPrefix(msg, "yaml: "))
+}
+
+// parseYAML parses *.{yml,yaml,json} config file, failing on malformed YAML/JSON, duplicate keys and anything but
+// a map with string keys at the top level.
+func parseYAML(file string, data []byte) (map[string]interface{}, error) {
+	if hasExtension(file, ".json") && len(bytes.TrimSpace(data)) != 0 {
+		// (YAML parser is more lenient (e.g. trailing commas are allowed))
+		var v interface{}
+		if err := json.Unmarshal(data, &v); err != nil {
+			if se, ok := err.(*json.SyntaxError); ok && int(se.Offset) <= len(data) {
+				return nil, fmt.Errorf("%s:%d: %s", file, bytes.Count(data[:se.Offset], []byte("\n"))+1, err.Error())
+			}
+			return nil, fmt.Errorf("%s: %s", file, err.Error())
+		}
+	}
+	// (gopkg.in/yaml.v2 is used to detect syntax errors as line numbers reported by gopkg.in/yaml.v3 are
+	// sometimes off by one)
+	var v interface{}
+	if err := yaml.Unmarshal(data, &v); err != nil {
+		return nil, configError(file, err)
+	}
+	doc, err := yamlext.ParseNode(data)
+	if err != nil {
+		return nil, configError(file, err)
+	}
+	if err := yamlext.CheckDuplicateKeys(doc); err != nil {
+		return nil, configError(file, err)
+	}
+	m := make(map[string]interface{})
+	if len(doc.Content) == 0 {
+		return m, nil // empty file
+	}
+	root := doc.Content[0]
+	if root.Kind == yamlv3.ScalarNode && root.Tag == "!!null" {
+		return m, nil
+	}
+	if root.Kind != yamlv3.MappingNode {
+		return nil, fmt.Errorf("%s:%d: expected a map (<key>: <value>)", file, root.Line)
+	}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key := root.Content[i]
+		if key.Kind != yamlv3.ScalarNode || (key.Tag != "!!str" && key.Tag != "!!merge") {
+			return nil, fmt.Errorf("%s:%d: top-level key %s is not a string (quote it if it is supposed to be one)",
+				file, key.Line, strconv.Quote(key.Value))
+		}
+	}
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return nil, configError(file, err)
+	}
+	return m, nil
+}
+
+func (r *Renderer) dotEnvOptions() []dotenv.Option {
+	if r.DotEnvInterpolate {
+		return []dotenv.Option{dotenv.Interpolate(os.LookupEnv)}
+	}
+	return nil
+}
+
+func parseDotEnv(file string, data []byte, options ...dotenv.Option) (map[string]interface{}, error) {
+	env, err := dotenv.Parse(data, options...)
+	if err != nil {
+		if e, ok := err.(*dotenv.Error); ok {
+			return nil, fmt.Errorf("%s:%d: %s", file, e.Line, e.Msg)
+		}
+		return nil, fmt.Errorf("%s: %s", file, err.Error())
+	}
+	m := make(map[string]interface{})
+	for key, value := range env {
+		m[key] = value
+	}
+	return m, nil
+}
+
+func hasExtensionAny(path string, ext ...string) bool {
+	for _, suffix := range ext {
+		if hasExtension(path, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasExtension(path string, ext string) bool {
+	if strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
+		path = strings.SplitN(path, "#", 2)[0]
+		path = strings.SplitN(path, "?", 2)[0]
+	}
+	return strings.HasSuffix(path, ext)
+}
+
+// ReadFile reads a file from disk, stdin ("-") or http(s):// URL.
+func ReadFile(path string) ([]byte, error) {
+	if path == "-" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	if isURL(path) {
+		res, err := http.Get(path)
+		if err != nil {
+			return nil, err
+		}
+		defer res.Body.Close()
+		if res.StatusCode != 200 {
+			return nil, fmt.Errorf(`GET "%s" %d`, path, res.StatusCode)
+		}
+		return ioutil.ReadAll(res.Body)
+	}
+	return ioutil.ReadFile(path)
+}
+
+func isURL(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
@@ -0,0 +1,259 @@
+package render
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	yamlext "github.com/shyiko/kubetpl/yaml"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ObjectKey identifies Kubernetes object.
+type ObjectKey struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+}
+
+// KeyOf returns ObjectKey of obj.
+func KeyOf(obj map[interface{}]interface{}) ObjectKey {
+	kind, _ := obj["kind"].(string)
+	meta, _ := obj["metadata"].(map[interface{}]interface{})
+	namespace, _ := meta["namespace"].(string)
+	name, _ := meta["name"].(string)
+	return ObjectKey{kind, namespace, name}
+}
+
+func (k ObjectKey) String() string {
+	if k.Namespace == "" {
+		return k.Kind + "/" + k.Name
+	}
+	return k.Kind + "/" + k.Namespace + "/" + k.Name
+}
+
+const (
+	DiffAdded   = "added"
+	DiffRemoved = "removed"
+	DiffChanged = "changed"
+)
+
+// FieldDiff is a difference in a single field (Path uses the same syntax as ${key.nested_key[0]} with list items
+// matched by "name" (if all of them have one) denoted as [name=<value>]).
+type FieldDiff struct {
+	Path string      `json:"path"`
+	Op   string      `json:"op"` // DiffAdded, DiffRemoved or DiffChanged
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// ObjectDiff is a difference between two versions of the same object.
+type ObjectDiff struct {
+	ObjectKey
+	Op     string      `json:"op"` // DiffAdded, DiffRemoved or DiffChanged
+	Fields []FieldDiff `json:"fields,omitempty"`
+}
+
+// Diff compares two sets of objects (matched by kind/namespace/name). Key order is irrelevant.
+// Result is sorted by kind/namespace/name (unchanged objects are omitted).
+// Error is returned if either of the sets contains more than one object with the same kind/namespace/name.
+func Diff(from, to []map[interface{}]interface{}) ([]ObjectDiff, error) {
+	index := func(objs []map[interface{}]interface{}) (map[ObjectKey]map[interface{}]interface{}, error) {
+		m := make(map[ObjectKey]map[interface{}]interface{}, len(objs))
+		for _, obj := range objs {
+			key := KeyOf(obj)
+			if _, ok := m[key]; ok {
+				return nil, fmt.Errorf(`Multiple "%s"s found`, key)
+			}
+			m[key] = obj
+		}
+		return m, nil
+	}
+	fromIndex, err := index(from)
+	if err != nil {
+		return nil, err
+	}
+	toIndex, err := index(to)
+	if err != nil {
+		return nil, err
+	}
+	var r []ObjectDiff
+	for key, a := range fromIndex {
+		b, ok := toIndex[key]
+		if !ok {
+			r = append(r, ObjectDiff{ObjectKey: key, Op: DiffRemoved})
+			continue
+		}
+		var fields []FieldDiff
+		diffValue("", yamlext.JSONCompatible(a), yamlext.JSONCompatible(b), &fields)
+		if len(fields) != 0 {
+			r = append(r, ObjectDiff{ObjectKey: key, Op: DiffChanged, Fields: fields})
+		}
+	}
+	for key := range toIndex {
+		if _, ok := fromIndex[key]; !ok {
+			r = append(r, ObjectDiff{ObjectKey: key, Op: DiffAdded})
+		}
+	}
+	sort.Slice(r, func(i, j int) bool {
+		a, b := r[i].ObjectKey, r[j].ObjectKey
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+	return r, nil
+}
+
+var plainPathKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+
+func joinPath(path string, key string) string {
+	if !plainPathKey.MatchString(key) {
+		q, _ := json.Marshal(key)
+		return path + "[" + string(q) + "]"
+	}
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func diffValue(path string, a, b interface{}, r *[]FieldDiff) {
+	switch av := a.(type) {
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(av)+len(bv))
+		for k := range av {
+			keys = append(keys, k)
+		}
+		for k := range bv {
+			if _, ok := av[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			x, inA := av[k]
+			y, inB := bv[k]
+			switch {
+			case !inB:
+				*r = append(*r, FieldDiff{Path: joinPath(path, k), Op: DiffRemoved, From: x})
+			case !inA:
+				*r = append(*r, FieldDiff{Path: joinPath(path, k), Op: DiffAdded, To: y})
+			default:
+				diffValue(joinPath(path, k), x, y, r)
+			}
+		}
+		return
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok {
+			break
+		}
+		if an, bn := namedItems(av), namedItems(bv); an != nil && bn != nil {
+			var names []string
+			seen := make(map[string]bool)
+			for _, item := range append(append([]interface{}{}, av...), bv...) {
+				name := item.(map[string]interface{})["name"].(string)
+				if !seen[name] {
+					seen[name] = true
+					names = append(names, name)
+				}
+			}
+			for _, name := range names {
+				p := path + "[name=" + name + "]"
+				x, inA := an[name]
+				y, inB := bn[name]
+				switch {
+				case !inB:
+					*r = append(*r, FieldDiff{Path: p, Op: DiffRemoved, From: x})
+				case !inA:
+					*r = append(*r, FieldDiff{Path: p, Op: DiffAdded, To: y})
+				default:
+					diffValue(p, x, y, r)
+				}
+			}
+			return
+		}
+		for i := 0; i < len(av) || i < len(bv); i++ {
+			p := fmt.Sprintf("%s[%d]", path, i)
+			switch {
+			case i >= len(bv):
+				*r = append(*r, FieldDiff{Path: p, Op: DiffRemoved, From: av[i]})
+			case i >= len(av):
+				*r = append(*r, FieldDiff{Path: p, Op: DiffAdded, To: bv[i]})
+			default:
+				diffValue(p, av[i], bv[i], r)
+			}
+		}
+		return
+	}
+	if !reflect.DeepEqual(a, b) {
+		*r = append(*r, FieldDiff{Path: path, Op: DiffChanged, From: a, To: b})
+	}
+}
+
+// namedItems returns list items indexed by "name" (nil unless each item is a map with a unique "name").
+func namedItems(items []interface{}) map[string]interface{} {
+	if len(items) == 0 {
+		return nil
+	}
+	m := make(map[string]interface{}, len(items))
+	for _, item := range items {
+		obj, ok := item.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		name, ok := obj["name"].(string)
+		if !ok || m[name] != nil {
+			return nil
+		}
+		m[name] = item
+	}
+	return m
+}
+
+// FormatDiff returns human-readable representation of the diff
+// ("+"/"-"/"~" in front of added/removed/changed objects & fields).
+func FormatDiff(diff []ObjectDiff) string {
+	var sb strings.Builder
+	for _, d := range diff {
+		switch d.Op {
+		case DiffAdded:
+			sb.WriteString("+ " + d.ObjectKey.String() + "\n")
+		case DiffRemoved:
+			sb.WriteString("- " + d.ObjectKey.String() + "\n")
+		default:
+			sb.WriteString("~ " + d.ObjectKey.String() + "\n")
+			for _, f := range d.Fields {
+				switch f.Op {
+				case DiffAdded:
+					sb.WriteString("    + " + f.Path + ": " + formatValue(f.To) + "\n")
+				case DiffRemoved:
+					sb.WriteString("    - " + f.Path + ": " + formatValue(f.From) + "\n")
+				default:
+					sb.WriteString("    ~ " + f.Path + ": " + formatValue(f.From) + " -> " + formatValue(f.To) + "\n")
+				}
+			}
+		}
+	}
+	return sb.String()
+}
+
+func formatValue(v interface{}) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
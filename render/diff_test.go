@@ -0,0 +1,78 @@
+package render
+
+import (
+	"gopkg.in/yaml.v2"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	parse := func(s string) []map[interface{}]interface{} {
+		var objs []map[interface{}]interface{}
+		for _, chunk := range strings.Split(s, "---\n") {
+			obj := make(map[interface{}]interface{})
+			if err := yaml.Unmarshal([]byte(chunk), &obj); err != nil {
+				t.Fatal(err)
+			}
+			objs = append(objs, obj)
+		}
+		return objs
+	}
+	diff, err := Diff(parse(`kind: ConfigMap
+metadata: {name: app, namespace: ns}
+data: {a: "1", b: "2", index.html: x}
+---
+kind: Deployment
+metadata: {name: app}
+spec:
+  containers: [{name: a, image: "a:1"}, {name: b, image: "b:1"}]
+  args: [x, y]
+---
+kind: Service
+metadata: {name: app}
+`), parse(`metadata: {namespace: ns, name: app}
+data: {index.html: z, c: "3", b: "2"}
+kind: ConfigMap
+---
+kind: Deployment
+metadata: {name: app}
+spec:
+  containers: [{name: b, image: "b:1"}, {name: a, image: "a:2"}]
+  args: [x, y]
+---
+kind: Secret
+metadata: {name: app}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual := FormatDiff(diff)
+	expected := `~ ConfigMap/ns/app
+    - data.a: "1"
+    + data.c: "3"
+    ~ data["index.html"]: "x" -> "z"
+~ Deployment/app
+    ~ spec.containers[name=a].image: "a:1" -> "a:2"
++ Secret/app
+- Service/app
+`
+	if actual != expected {
+		t.Fatalf("actual: \n%s != expected: \n%s", actual, expected)
+	}
+}
+
+func TestDiffRejectsDuplicates(t *testing.T) {
+	obj := func() map[interface{}]interface{} {
+		return map[interface{}]interface{}{
+			"kind":     "ConfigMap",
+			"metadata": map[interface{}]interface{}{"name": "app", "namespace": "ns"},
+		}
+	}
+	if _, err := Diff([]map[interface{}]interface{}{obj()}, []map[interface{}]interface{}{obj(), obj()}); err == nil ||
+		err.Error() != `Multiple "ConfigMap/ns/app"s found` {
+		t.Fatalf("expected duplicate to be reported, instead got %v", err)
+	}
+	if _, err := Diff([]map[interface{}]interface{}{obj(), obj()}, nil); err == nil {
+		t.Fatal("expected duplicate to be reported")
+	}
+}
@@ -0,0 +1,56 @@
+package render
+
+import (
+	"sort"
+	"strings"
+)
+
+// EnvOptions controls which environment variables ReadEnv imports.
+type EnvOptions struct {
+	// Import variables whose names start with any of the prefixes (e.g. "APP_").
+	Prefixes []string
+	// Keep prefix (by default "APP_DB_HOST" is imported as "DB_HOST").
+	KeepPrefix bool
+	// Import variables with the given names (as is). Variables that aren't set are skipped.
+	Names []string
+}
+
+// ReadEnv imports environment variables (environ is in the os.Environ() format) according to opts.
+// Variables listed in opts.Names take precedence over the ones matched by opts.Prefixes
+// (which, in turn, are applied in order, with latter taking precedence over former).
+func ReadEnv(environ []string, opts EnvOptions) map[string]interface{} {
+	env := make(map[string]string, len(environ))
+	var keys []string
+	for _, kv := range environ {
+		split := strings.SplitN(kv, "=", 2)
+		if len(split) != 2 || split[0] == "" {
+			continue
+		}
+		if _, ok := env[split[0]]; !ok {
+			keys = append(keys, split[0])
+		}
+		env[split[0]] = split[1]
+	}
+	sort.Strings(keys)
+	config := make(map[string]interface{})
+	for _, prefix := range opts.Prefixes {
+		for _, key := range keys {
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			name := key
+			if !opts.KeepPrefix {
+				name = strings.TrimPrefix(key, prefix)
+			}
+			if name != "" {
+				config[name] = env[key]
+			}
+		}
+	}
+	for _, name := range opts.Names {
+		if value, ok := env[name]; ok {
+			config[name] = value
+		}
+	}
+	return config
+}
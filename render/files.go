@@ -0,0 +1,284 @@
+package render
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// IgnoreFile lists (.gitignore-style) patterns of files/directories to skip while expanding directory/glob
+// arguments. Patterns are relative to the directory containing IgnoreFile.
+const IgnoreFile = ".kubetplignore"
+
+// templateExtensions are the extensions of the files picked up from directories/glob patterns.
+var templateExtensions = []string{".yml", ".yaml", ".json", ".kubetpl", ".kubetpl-go"}
+
+func (r *Renderer) readDir(dir string) ([]os.FileInfo, error) {
+	if r.ReadDir != nil {
+		return r.ReadDir(dir)
+	}
+	return ioutil.ReadDir(dir)
+}
+
+// ExpandTemplateFiles replaces directories (recursively) and glob patterns (e.g. "k8s/**/*.yml") with the template
+// files they contain/match ({yml,yaml,json,kubetpl,kubetpl-go} only, hidden files, PackageManifest|s,
+// Lib directories, config files read so far (see ReadConfigFiles) and anything listed in IgnoreFile|s excluded)
+// in lexicographical order.
+// Regular files, stdin ("-") and URLs are kept as is. Duplicates are removed.
+func (r *Renderer) ExpandTemplateFiles(paths []string) ([]string, error) {
+	exclude := make(map[string]bool)
+	for file := range r.configFiles {
+		exclude[file] = true
+	}
+	for _, dir := range r.Lib {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, err
+		}
+		exclude[abs] = true
+	}
+	var res []string
+	seen := make(map[string]bool)
+	add := func(file string) {
+		key := file
+		if file != "-" && !isURL(file) {
+			key = filepath.Clean(file)
+		}
+		if !seen[key] || file == "-" {
+			seen[key] = true
+			res = append(res, file)
+		}
+	}
+	for _, path := range paths {
+		if path == "-" || isURL(path) {
+			add(path)
+			continue
+		}
+		if fi, err := os.Stat(path); err == nil && fi.IsDir() {
+			files, err := r.findTemplates(path, nil, exclude)
+			if err != nil {
+				return nil, err
+			}
+			if len(files) == 0 {
+				return nil, fmt.Errorf("%s: no templates found", path)
+			}
+			for _, file := range files {
+				add(file)
+			}
+			continue
+		} else if err == nil || !isGlob(path) {
+			add(path)
+			continue
+		}
+		base, pattern := splitGlob(path)
+		re, err := globRegexp(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err.Error())
+		}
+		files, err := r.findTemplates(base, re, exclude)
+		if err != nil {
+			return nil, err
+		}
+		if len(files) == 0 {
+			return nil, fmt.Errorf("%s: no templates matched", path)
+		}
+		for _, file := range files {
+			add(file)
+		}
+	}
+	return res, nil
+}
+
+func isGlob(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// splitGlob splits path into the directory without any glob metacharacters and the (slash-separated) pattern
+// relative to it, e.g. "k8s/*/**/*.yml" -> "k8s", "*/**/*.yml".
+func splitGlob(path string) (string, string) {
+	segments := strings.Split(filepath.ToSlash(path), "/")
+	for i, segment := range segments {
+		if isGlob(segment) {
+			base := strings.Join(segments[:i], "/")
+			if base == "" && i > 0 {
+				base = "/"
+			} else if base == "" {
+				base = "."
+			}
+			return filepath.FromSlash(base), strings.Join(segments[i:], "/")
+		}
+	}
+	return filepath.Dir(path), filepath.Base(path)
+}
+
+// globRegexp converts glob pattern into a regexp ("*" and "?" do not match "/", "**" matches any number of
+// directories, [...] (with "!" for negation) match a character class).
+func globRegexp(pattern string) (*regexp.Regexp, error) {
+	var buf strings.Builder
+	buf.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch c {
+		case '*':
+			if strings.HasPrefix(pattern[i:], "**/") {
+				buf.WriteString("(?:.*/)?")
+				i += 2
+			} else if strings.HasPrefix(pattern[i:], "**") {
+				buf.WriteString(".*")
+				i++
+			} else {
+				buf.WriteString("[^/]*")
+			}
+		case '?':
+			buf.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end == -1 {
+				buf.WriteString(regexp.QuoteMeta(string(c)))
+				continue
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			buf.WriteString("[" + strings.Replace(class, `\`, `\\`, -1) + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+			}
+			buf.WriteString(regexp.QuoteMeta(string(pattern[i])))
+		default:
+			buf.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	buf.WriteString("$")
+	return regexp.Compile(buf.String())
+}
+
+type ignoreRule struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// ignoreList is a parsed IgnoreFile located in dir (slash-separated, relative to the root of the walk).
+type ignoreList struct {
+	dir   string
+	rules []ignoreRule
+}
+
+func parseIgnoreFile(file string, data []byte) ([]ignoreRule, error) {
+	var rules []ignoreRule
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var rule ignoreRule
+		if strings.HasPrefix(line, "!") {
+			rule.negate, line = true, line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly, line = true, strings.TrimRight(line, "/")
+		}
+		if strings.HasPrefix(line, "/") {
+			line = line[1:]
+		} else if !strings.Contains(line, "/") {
+			line = "**/" + line // matches at any depth
+		}
+		re, err := globRegexp(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", file, i+1, err.Error())
+		}
+		rule.re = re
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func isIgnored(ignores []ignoreList, rel string, isDir bool) bool {
+	var ignored bool
+	for _, l := range ignores {
+		path := rel
+		if l.dir != "" {
+			path = strings.TrimPrefix(rel, l.dir+"/")
+		}
+		for _, rule := range l.rules {
+			if (!rule.dirOnly || isDir) && rule.re.MatchString(path) {
+				ignored = !rule.negate
+			}
+		}
+	}
+	return ignored
+}
+
+// findTemplates walks root (depth-first, entries in lexicographical order) collecting template files
+// (whose slash-separated path relative to the root matches re (if not nil)). Files/directories whose absolute
+// path is in exclude are skipped.
+func (r *Renderer) findTemplates(root string, re *regexp.Regexp, exclude map[string]bool) ([]string, error) {
+	var files []string
+	var walk func(dir string, rel string, ignores []ignoreList) error
+	walk = func(dir string, rel string, ignores []ignoreList) error {
+		entries, err := r.readDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, fi := range entries {
+			if fi.Name() == IgnoreFile && fi.Mode().IsRegular() {
+				file := filepath.Join(dir, IgnoreFile)
+				data, err := r.readFile(file)
+				if err != nil {
+					return err
+				}
+				rules, err := parseIgnoreFile(file, data)
+				if err != nil {
+					return err
+				}
+				ignores = append(ignores[:len(ignores):len(ignores)], ignoreList{rel, rules})
+			}
+		}
+		for _, fi := range entries {
+			name := fi.Name()
+			if strings.HasPrefix(name, ".") {
+				continue
+			}
+			path := filepath.Join(dir, name)
+			relPath := name
+			if rel != "" {
+				relPath = rel + "/" + name
+			}
+			if fi.Mode()&os.ModeSymlink != 0 {
+				// symlinks to files are followed, symlinks to directories are not (to avoid cycles)
+				if fi, err = os.Stat(path); err != nil || fi.IsDir() {
+					continue
+				}
+			}
+			if isIgnored(ignores, relPath, fi.IsDir()) {
+				continue
+			}
+			if abs, err := filepath.Abs(path); err == nil && exclude[abs] {
+				continue
+			}
+			if fi.IsDir() {
+				if err := walk(path, relPath, ignores); err != nil {
+					return err
+				}
+				continue
+			}
+			if name == PackageManifest || !hasExtensionAny(name, templateExtensions...) ||
+				(re != nil && !re.MatchString(relPath)) {
+				continue
+			}
+			files = append(files, path)
+		}
+		return nil
+	}
+	if err := walk(root, "", nil); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
@@ -0,0 +1,304 @@
+package render
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/shyiko/kubetpl/engine"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const DirectiveInclude = "include"
+
+// includeRoots returns directories "# kubetpl:include:<path>" (and --lib) are allowed to read files from:
+// Lib directories and either Chroot or, if not set, the directory of the template.
+// Lib directories outside of Chroot (if set) are rejected.
+func (r *Renderer) includeRoots(templateFile string) ([]string, error) {
+	var chroot string
+	if r.Chroot != "" {
+		var err error
+		if chroot, err = filepath.Abs(r.Chroot); err != nil {
+			return nil, err
+		}
+	}
+	var roots []string
+	for _, dir := range r.Lib {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, err
+		}
+		if chroot != "" && !isWithin(abs, []string{chroot}) {
+			return nil, fmt.Errorf("--lib %s is outside of --chroot %s", dir, r.Chroot)
+		}
+		roots = append(roots, abs)
+	}
+	if chroot != "" {
+		roots = append(roots, chroot)
+	} else if !isURL(templateFile) {
+		dir, err := dirnameAbs(templateFile)
+		if err != nil {
+			return nil, err
+		}
+		roots = append(roots, dir)
+	}
+	return roots, nil
+}
+
+func isWithin(file string, roots []string) bool {
+	for _, root := range roots {
+		if file == root || strings.HasPrefix(file, strings.TrimSuffix(root, string(filepath.Separator))+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// sourceLine is a position of the line of the template (after "# kubetpl:include:"s are expanded) within
+// the file it came from.
+type sourceLine struct {
+	file string
+	line int
+	// number of characters line was indented by when included
+	indent int
+}
+
+// lineMap maps lines of the template (after "# kubetpl:include:"s are expanded) back to the files they came from
+// (nil if template does not include anything, in which case lines are not remapped).
+type lineMap []sourceLine
+
+// resolve returns file, line & column (1-based) the position within expanded template corresponds to
+// (file is "" if position wasn't remapped).
+func (m lineMap) resolve(line, column int) (string, int, int) {
+	if line < 1 || line > len(m) {
+		return "", line, column
+	}
+	s := m[line-1]
+	if column > s.indent {
+		column -= s.indent
+	}
+	return s.file, s.line, column
+}
+
+var goTemplateErrorPosition = regexp.MustCompile(`^template: (.+?):(\d+)(?::(\d+))?:`)
+
+// remapError updates positions (in engine.VarError|s and go-template errors) to point to the files lines came from.
+// engine.VarError|s located in other (included) files are returned as *Error.
+func (m lineMap) remapError(file string, err error) error {
+	if m == nil {
+		return err
+	}
+	if errs, ok := err.(engine.Errors); ok {
+		r := make(engine.Errors, len(errs))
+		for i, err := range errs {
+			r[i] = m.remapError(file, err)
+		}
+		return r
+	}
+	if ve, ok := err.(*engine.VarError); ok && ve.Line != 0 {
+		e := *ve
+		var source string
+		source, e.Line, e.Column = m.resolve(ve.Line, ve.Column)
+		if source != "" && source != file {
+			return &Error{Source: source, Err: &e}
+		}
+		return &e
+	}
+	msg := err.Error()
+	if sm := goTemplateErrorPosition.FindStringSubmatch(msg); sm != nil && sm[1] == file {
+		line, _ := strconv.Atoi(sm[2])
+		column, _ := strconv.Atoi(sm[3])
+		source, line, column := m.resolve(line, column)
+		if source == "" {
+			source = file
+		}
+		position := fmt.Sprintf("%s:%d", source, line)
+		if sm[3] != "" {
+			position += fmt.Sprintf(":%d", column)
+		}
+		return errors.New("template: " + position + ":" + msg[len(sm[0]):])
+	}
+	return err
+}
+
+// expandIncludes replaces "# kubetpl:include:<path>" directives with the content of the referenced files
+// (indented the same way directive is). Relative paths are resolved against the directory of the file containing
+// directive and then against each of the Lib directories.
+func (r *Renderer) expandIncludes(file string, content []byte, roots []string, stack []string) ([]byte, lineMap, error) {
+	if !bytes.Contains(content, []byte("# kubetpl:"+DirectiveInclude+":")) {
+		return content, nil, nil
+	}
+	var buf bytes.Buffer
+	var m lineMap
+	lines := strings.SplitAfter(string(content), "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimLeft(line, " ")
+		if !strings.HasPrefix(trimmed, "# kubetpl:"+DirectiveInclude+":") {
+			buf.WriteString(line)
+			if line != "" {
+				m = append(m, sourceLine{file, i + 1, 0})
+			}
+			continue
+		}
+		indent := line[:len(line)-len(trimmed)]
+		path := strings.TrimSpace(strings.TrimPrefix(trimmed, "# kubetpl:"+DirectiveInclude+":"))
+		if path == "" {
+			return nil, nil, fmt.Errorf("%s:%d: # kubetpl:include: path is missing", file, i+1)
+		}
+		included, data, err := r.readInclude(file, path, roots)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s:%d: %s", file, i+1, err.Error())
+		}
+		for _, f := range stack {
+			if f == included {
+				return nil, nil, fmt.Errorf("%s:%d: include cycle (%s -> %s)", file, i+1, strings.Join(stack, " -> "), included)
+			}
+		}
+		data = bytes.Replace(data, []byte("\r\n"), []byte("\n"), -1)
+		data, includedMap, err := r.expandIncludes(included, data, roots, append(stack, included))
+		if err != nil {
+			return nil, nil, err
+		}
+		for k, l := range strings.SplitAfter(strings.TrimSuffix(string(data), "\n"), "\n") {
+			if strings.HasPrefix(l, "# kubetpl:"+DirectiveSyntax+":") {
+				continue // flavor is determined by the including template
+			}
+			source := sourceLine{included, k + 1, 0}
+			if k < len(includedMap) {
+				source = includedMap[k]
+			}
+			if strings.TrimSpace(l) != "" {
+				buf.WriteString(indent)
+				source.indent += len(indent)
+			}
+			buf.WriteString(l)
+			m = append(m, source)
+		}
+		if strings.HasSuffix(line, "\n") {
+			buf.WriteString("\n")
+		}
+	}
+	return buf.Bytes(), m, nil
+}
+
+func (r *Renderer) readInclude(file string, path string, roots []string) (string, []byte, error) {
+	var candidates []string
+	if filepath.IsAbs(path) {
+		candidates = append(candidates, path)
+	} else {
+		if !isURL(file) {
+			dir, err := dirnameAbs(file)
+			if err != nil {
+				return "", nil, err
+			}
+			candidates = append(candidates, filepath.Join(dir, path))
+		}
+		for _, dir := range r.Lib {
+			candidates = append(candidates, filepath.Join(dir, path))
+		}
+	}
+	var firstErr error
+	for _, candidate := range candidates {
+		abs, err := filepath.Abs(candidate)
+		if err != nil {
+			return "", nil, err
+		}
+		if !isWithin(abs, roots) {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("access denied: %s (use -c/--chroot=<root dir> or --lib=<dir> to allow)",
+					relativeToCwd(abs))
+			}
+			continue
+		}
+		data, err := r.readFile(abs)
+		if err != nil {
+			if os.IsNotExist(err) {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("%s not found", path)
+				}
+				continue
+			}
+			return "", nil, err
+		}
+		return abs, data, nil
+	}
+	if firstErr == nil {
+		firstErr = fmt.Errorf("%s not found", path)
+	}
+	return "", nil, firstErr
+}
+
+// readLib returns content of all the files within Lib directories (keyed by path relative to the directory
+// (with "/" as a separator), e.g. "probes/http.yml"). Hidden files/directories and files without one of the
+// templateExtensions are skipped.
+func (r *Renderer) readLib() (map[string][]byte, error) {
+	if len(r.Lib) == 0 {
+		return nil, nil
+	}
+	var chroot string
+	if r.Chroot != "" {
+		var err error
+		if chroot, err = filepath.Abs(r.Chroot); err != nil {
+			return nil, err
+		}
+	}
+	lib := make(map[string][]byte)
+	for _, dir := range r.Lib {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, err
+		}
+		if chroot != "" && !isWithin(abs, []string{chroot}) {
+			return nil, fmt.Errorf("--lib %s is outside of --chroot %s", dir, r.Chroot)
+		}
+		var files []string
+		err = filepath.Walk(abs, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if path != abs && strings.HasPrefix(info.Name(), ".") {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if info.Mode().IsRegular() && hasExtensionAny(info.Name(), templateExtensions...) {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(files)
+		for _, file := range files {
+			rel, err := filepath.Rel(abs, file)
+			if err != nil {
+				return nil, err
+			}
+			name := filepath.ToSlash(rel)
+			if _, ok := lib[name]; ok {
+				continue // first --lib wins
+			}
+			data, err := r.readFile(file)
+			if err != nil {
+				return nil, err
+			}
+			lib[name] = bytes.Replace(data, []byte("\r\n"), []byte("\n"), -1)
+		}
+	}
+	return lib, nil
+}
+
+func relativeToCwd(file string) string {
+	if cwd, err := os.Getwd(); err == nil {
+		if p, err := filepath.Rel(cwd, file); err == nil {
+			return p
+		}
+	}
+	return file
+}
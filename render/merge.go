@@ -0,0 +1,195 @@
+package render
+
+import (
+	"fmt"
+	"github.com/shyiko/kubetpl/engine"
+	yamlext "github.com/shyiko/kubetpl/yaml"
+)
+
+// List merge strategies (see Options.ListMerge).
+const (
+	// lists from the latter config file replace the ones from the former (default)
+	ListMergeReplace = "replace"
+	// lists from the latter config file are appended to the ones from the former
+	ListMergeAppend = "append"
+	// list items (maps) with the same value of the Options.ListMergeKey are merged, the rest are appended
+	ListMergeByKey = "merge-by-key"
+)
+
+// DefaultListMergeKey is the key ListMergeByKey matches list items by (unless Options.ListMergeKey is set).
+const DefaultListMergeKey = "name"
+
+func (r *Renderer) listMerge() (string, string, error) {
+	strategy, key := r.ListMerge, r.ListMergeKey
+	switch strategy {
+	case "":
+		strategy = ListMergeReplace
+	case ListMergeReplace, ListMergeAppend, ListMergeByKey:
+	default:
+		return "", "", fmt.Errorf(`Unknown list merge strategy "%s" (expected replace, append or merge-by-key)`, strategy)
+	}
+	if key == "" {
+		key = DefaultListMergeKey
+	}
+	return strategy, key, nil
+}
+
+// mergeValue deep merges src into dst (maps are merged recursively, lists according to the strategy,
+// anything else in src replaces dst).
+func mergeValue(dst interface{}, src interface{}, strategy string, key string) interface{} {
+	switch s := src.(type) {
+	case map[interface{}]interface{}:
+		d, ok := dst.(map[interface{}]interface{})
+		if !ok {
+			return src
+		}
+		for k, v := range s {
+			if dv, ok := d[k]; ok {
+				d[k] = mergeValue(dv, v, strategy, key)
+			} else {
+				d[k] = v
+			}
+		}
+		return d
+	case []interface{}:
+		d, ok := dst.([]interface{})
+		if !ok {
+			return src
+		}
+		switch strategy {
+		case ListMergeAppend:
+			return append(d, s...)
+		case ListMergeByKey:
+			for _, item := range s {
+				id, ok := listItemKey(item, key)
+				if !ok {
+					d = append(d, item)
+					continue
+				}
+				merged := false
+				for i, dItem := range d {
+					if dID, ok := listItemKey(dItem, key); ok && dID == id {
+						d[i], merged = mergeValue(dItem, item, strategy, key), true
+						break
+					}
+				}
+				if !merged {
+					d = append(d, item)
+				}
+			}
+			return d
+		}
+	}
+	return src
+}
+
+func listItemKey(item interface{}, key string) (interface{}, bool) {
+	m, ok := item.(map[interface{}]interface{})
+	if !ok {
+		return nil, false
+	}
+	v, ok := m[key]
+	// (only scalars are compared)
+	return v, ok && v != nil && yamlext.IsBasicType(v)
+}
+
+// MergeConfig deep merges src into dst (see ListMerge* for the ways lists can be merged).
+func (r *Renderer) MergeConfig(dst map[string]interface{}, src map[string]interface{}) error {
+	strategy, key, err := r.listMerge()
+	if err != nil {
+		return err
+	}
+	for k, v := range src {
+		// (src is copied so that dst never shares maps/lists with it (mergeValue modifies dst in place))
+		v = copyValue(v)
+		if dv, ok := dst[k]; ok {
+			dst[k] = mergeValue(dv, v, strategy, key)
+		} else {
+			dst[k] = v
+		}
+	}
+	return nil
+}
+
+// copyValue returns a deep copy of maps/lists (anything else is returned as is).
+func copyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[interface{}]interface{}, len(v))
+		for k, item := range v {
+			m[k] = copyValue(item)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			m[k] = copyValue(item)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, item := range v {
+			l[i] = copyValue(item)
+		}
+		return l
+	}
+	return value
+}
+
+// SetValue sets value at path (e.g. "db.host", "hosts[0]"), creating intermediate maps/lists as needed.
+// Index equal to the length of the list appends to it.
+// Top-level key equal to path (e.g. "db.host: a" coming from a config file) is removed
+// (otherwise it would take precedence over the value being set (see engine.Lookup)).
+func SetValue(config map[string]interface{}, path string, value interface{}) error {
+	segments, err := engine.ParsePath(path)
+	if err != nil {
+		return err
+	}
+	root, ok := segments[0].(string)
+	if !ok {
+		return fmt.Errorf(`"%s" is not a valid path`, path)
+	}
+	v, err := setValue(config[root], segments[1:], value, path)
+	if err != nil {
+		return err
+	}
+	config[root] = v
+	if len(segments) > 1 {
+		delete(config, path)
+	}
+	return nil
+}
+
+func setValue(node interface{}, segments []interface{}, value interface{}, path string) (interface{}, error) {
+	if len(segments) == 0 {
+		return value, nil
+	}
+	switch segment := segments[0].(type) {
+	case string:
+		m, ok := node.(map[interface{}]interface{})
+		if !ok {
+			m = make(map[interface{}]interface{})
+		}
+		v, err := setValue(m[segment], segments[1:], value, path)
+		if err != nil {
+			return nil, err
+		}
+		m[segment] = v
+		return m, nil
+	case int:
+		l, _ := node.([]interface{})
+		if segment < 0 || segment > len(l) {
+			return nil, fmt.Errorf(`"%s": index %d is out of range (list has %d item(s))`, path, segment, len(l))
+		}
+		if segment == len(l) {
+			l = append(l, nil)
+		}
+		v, err := setValue(l[segment], segments[1:], value, path)
+		if err != nil {
+			return nil, err
+		}
+		l[segment] = v
+		return l, nil
+	}
+	return nil, fmt.Errorf(`"%s" is not a valid path`, path)
+}
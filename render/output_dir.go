@@ -0,0 +1,194 @@
+package render
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/shyiko/kubetpl/engine"
+	yamlext "github.com/shyiko/kubetpl/yaml"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// DefaultNamePattern is the default DirOptions.NamePattern (".yaml" is replaced with ".json" unless
+// DirOptions.Format is FormatYAML).
+const DefaultNamePattern = "{{kind}}-{{metadata.name}}.yaml"
+
+// defaultNamePattern returns DefaultNamePattern with extension matching the format.
+func defaultNamePattern(format string) string {
+	if format != FormatYAML {
+		return strings.TrimSuffix(DefaultNamePattern, ".yaml") + ".json"
+	}
+	return DefaultNamePattern
+}
+
+// DirIndexFile is the file (within the output directory) used to keep track of files written by WriteDir.
+const DirIndexFile = ".kubetpl-files"
+
+// DirOptions controls how Result.WriteDir lays out the output.
+type DirOptions struct {
+	// File name pattern ("{{<path>}}"s are replaced with the corresponding values of the object, e.g.
+	// "{{metadata.namespace}}/{{kind}}-{{metadata.name}}.yaml" groups objects by namespace).
+	// DefaultNamePattern (with extension matching Format) is used if empty.
+	NamePattern string
+	// Output format (FormatYAML if empty).
+	Format string
+	// Remove files written by the previous WriteDir (and not by this one).
+	Clean bool
+}
+
+var namePlaceholder = regexp.MustCompile(`\{\{\s*([^{}]*?)\s*\}\}`)
+var unsafeNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// FileName returns the name of the file (relative to the output directory) the object is to be written to.
+func FileName(pattern string, obj map[interface{}]interface{}) (string, error) {
+	if pattern == "" {
+		pattern = DefaultNamePattern
+	}
+	data, _ := yamlext.JSONCompatible(obj).(map[string]interface{})
+	var segments []string
+	for _, segment := range strings.Split(filepath.ToSlash(pattern), "/") {
+		segment = namePlaceholder.ReplaceAllStringFunc(segment, func(m string) string {
+			v, ok := engine.Lookup(data, namePlaceholder.FindStringSubmatch(m)[1])
+			if !ok || v == nil {
+				return ""
+			}
+			return unsafeNameChars.ReplaceAllString(fmt.Sprintf("%v", v), "_")
+		})
+		if segment == "" || segment == "." || segment == ".." {
+			// e.g. "{{metadata.namespace}}/" of a cluster-scoped object
+			continue
+		}
+		segments = append(segments, segment)
+	}
+	if len(segments) == 0 {
+		return "", fmt.Errorf(`"%s" resolved to an empty file name`, pattern)
+	}
+	return filepath.Join(segments...), nil
+}
+
+// WriteDir writes each (non-empty) document to a separate file within dir.
+// Names of the files written are recorded in dir/DirIndexFile (so that stale files could be cleaned up next time).
+func (r *Result) WriteDir(dir string, opts DirOptions) ([]string, error) {
+	format := opts.Format
+	if format == "" {
+		format = FormatYAML
+	}
+	pattern := opts.NamePattern
+	if pattern == "" {
+		pattern = defaultNamePattern(format)
+	}
+	files := make(map[string][]byte)
+	owner := make(map[string]Document)
+	var names []string
+	for _, doc := range r.Documents {
+		if len(doc.Object) == 0 {
+			continue
+		}
+		name, err := FileName(pattern, doc.Object)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", doc.Source, err.Error())
+		}
+		if prev, ok := owner[name]; ok {
+			return nil, fmt.Errorf("%s/%s and %s/%s are both to be written to %s (adjust name pattern to make it unique)",
+				prev.Kind(), prev.Name(), doc.Kind(), doc.Name(), filepath.Join(dir, name))
+		}
+		content, err := (&Result{Documents: []Document{doc}}).Encode(format)
+		if err != nil {
+			return nil, err
+		}
+		files[name] = bytes.TrimPrefix(content, []byte("---\n"))
+		owner[name] = doc
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	previous, err := readDirIndex(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		file := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return nil, err
+		}
+		if err := ioutil.WriteFile(file, files[name], 0600); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Clean {
+		for _, name := range previous {
+			if _, ok := files[name]; ok {
+				continue
+			}
+			if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+			removeEmptyParents(dir, filepath.Dir(filepath.Join(dir, name)))
+		}
+	} else {
+		// keep track of the files that haven't been cleaned up yet
+		for _, name := range previous {
+			if _, ok := files[name]; !ok {
+				if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+					names = append(names, name)
+				}
+			}
+		}
+		sort.Strings(names)
+	}
+	if err := writeDirIndex(dir, names); err != nil {
+		return nil, err
+	}
+	var written []string
+	for name := range files {
+		written = append(written, filepath.Join(dir, name))
+	}
+	sort.Strings(written)
+	return written, nil
+}
+
+func readDirIndex(dir string) ([]string, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, DirIndexFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var names []string
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		name := filepath.FromSlash(strings.TrimSpace(scanner.Text()))
+		if name == "" || strings.HasPrefix(name, "#") || filepath.IsAbs(name) ||
+			strings.HasPrefix(filepath.Clean(name), "..") {
+			continue
+		}
+		names = append(names, filepath.Clean(name))
+	}
+	return names, scanner.Err()
+}
+
+func writeDirIndex(dir string, names []string) error {
+	var buf bytes.Buffer
+	buf.WriteString("# files generated by kubetpl (used by --output-dir-clean to remove stale files)\n")
+	for _, name := range names {
+		buf.WriteString(filepath.ToSlash(name) + "\n")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, DirIndexFile), buf.Bytes(), 0600)
+}
+
+func removeEmptyParents(root string, dir string) {
+	root = filepath.Clean(root)
+	for dir = filepath.Clean(dir); dir != root && strings.HasPrefix(dir, root); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil { // fails if not empty
+			return
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package render
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestWriteDirDefaultExtension(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubetpl-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	res := &Result{Documents: []Document{{Object: map[interface{}]interface{}{
+		"kind": "ConfigMap", "metadata": map[interface{}]interface{}{"name": "a"},
+	}}}}
+	for format, expected := range map[string]string{"": "ConfigMap-a.yaml", FormatYAML: "ConfigMap-a.yaml",
+		FormatJSON: "ConfigMap-a.json", FormatJSONLines: "ConfigMap-a.json"} {
+		files, err := res.WriteDir(dir, DirOptions{Format: format})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(files, []string{filepath.Join(dir, expected)}) {
+			t.Fatalf("%s: actual: \n%v != expected: \n%v", format, files, expected)
+		}
+	}
+}
+
+func TestWriteDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubetpl-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	doc := func(kind, namespace, name string) Document {
+		meta := map[interface{}]interface{}{"name": name}
+		if namespace != "" {
+			meta["namespace"] = namespace
+		}
+		return Document{Object: map[interface{}]interface{}{"kind": kind, "metadata": meta}}
+	}
+	opts := DirOptions{NamePattern: "{{metadata.namespace}}/{{kind}}-{{metadata.name}}.yaml", Clean: true}
+	res := &Result{Documents: []Document{doc("ConfigMap", "ns", "a"), doc("Namespace", "", "ns"), {}}}
+	if _, err := res.WriteDir(dir, opts); err != nil {
+		t.Fatal(err)
+	}
+	actual, err := ioutil.ReadFile(filepath.Join(dir, "ns", "ConfigMap-a.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "kind: ConfigMap\nmetadata:\n  name: a\n  namespace: ns\n"
+	if string(actual) != expected {
+		t.Fatalf("actual: \n%s != expected: \n%s", actual, expected)
+	}
+	for _, file := range []string{"Namespace-ns.yaml", DirIndexFile} {
+		info, err := os.Stat(filepath.Join(dir, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0600 {
+			t.Fatalf("%s: actual: %v != expected: %v", file, info.Mode().Perm(), os.FileMode(0600))
+		}
+	}
+	res = &Result{Documents: []Document{doc("Namespace", "", "ns")}}
+	written, err := res.WriteDir(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(written, []string{filepath.Join(dir, "Namespace-ns.yaml")}) {
+		t.Fatal(written)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "ns")); !os.IsNotExist(err) {
+		t.Fatal("stale file/directory wasn't removed")
+	}
+	res = &Result{Documents: []Document{doc("ConfigMap", "", "a"), doc("ConfigMap", "", "a")}}
+	if _, err := res.WriteDir(dir, opts); err == nil {
+		t.Fatal("expected name collision to be reported")
+	}
+}
@@ -0,0 +1,324 @@
+package render
+
+import (
+	"fmt"
+	"github.com/shyiko/kubetpl/engine"
+	"gopkg.in/yaml.v2"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// PackageManifest is the name of the file that turns a directory into a package.
+const PackageManifest = "kubetpl.yaml"
+
+// Package is a directory containing PackageManifest, e.g.
+//
+//	kubetpl.yaml
+//	defaults.yaml   (optional)
+//	envs/           (optional)
+//	  staging.env
+//	templates/
+//	  deployment.yml
+type Package struct {
+	// Absolute path to the package directory.
+	Dir string `yaml:"-"`
+	// Package name (informational).
+	Name string `yaml:"name"`
+	// Default template flavor (for templates without "# kubetpl:syntax:<flavor>").
+	Syntax string `yaml:"syntax"`
+	// Templates (relative to Dir) (templates/*.{yml,yaml} if empty).
+	Templates []string `yaml:"templates"`
+	// Lib directories (relative to Dir) (see Options.Lib).
+	Lib []string `yaml:"lib"`
+	// Config file(s) applied before env-specific ones (defaults.yaml (if exists) if empty).
+	Defaults []string `yaml:"defaults"`
+	// Variables package expects.
+	Vars []PackageVar `yaml:"vars"`
+	// env -> config file(s) (relative to Dir) (envs/<env>.{env,yml,yaml,json} if env is not listed).
+	Envs map[string][]string `yaml:"envs"`
+}
+
+// PackageVar is a variable declared in the PackageManifest.
+type PackageVar struct {
+	Name        string      `yaml:"name"`
+	Description string      `yaml:"description"`
+	Type        string      `yaml:"type"` // string, int, bool or base64
+	Required    bool        `yaml:"required"`
+	Default     interface{} `yaml:"default"`
+}
+
+// IsPackage returns true if dir contains PackageManifest.
+func IsPackage(dir string) bool {
+	fi, err := os.Stat(filepath.Join(dir, PackageManifest))
+	return err == nil && fi.Mode().IsRegular()
+}
+
+// LoadPackage reads dir/PackageManifest.
+func LoadPackage(dir string) (*Package, error) {
+	return loadPackage(dir, ReadFile)
+}
+
+func loadPackage(dir string, readFile func(path string) ([]byte, error)) (*Package, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	manifest := filepath.Join(dir, PackageManifest)
+	b, err := readFile(manifest)
+	if err != nil {
+		return nil, err
+	}
+	var p Package
+	if err := yaml.UnmarshalStrict(b, &p); err != nil {
+		return nil, fmt.Errorf("%s: %s", manifest, err.Error())
+	}
+	p.Dir = abs
+	for _, v := range p.Vars {
+		if v.Name == "" {
+			return nil, fmt.Errorf("%s: vars[].name must be set", manifest)
+		}
+		if err := engine.ValidateParameterType(v.Name, v.Type, v.Default); err != nil {
+			return nil, fmt.Errorf("%s: %s", manifest, err.Error())
+		}
+	}
+	if len(p.Templates) == 0 {
+		for _, pattern := range []string{"*.yml", "*.yaml"} {
+			m, err := filepath.Glob(filepath.Join(abs, "templates", pattern))
+			if err != nil {
+				return nil, err
+			}
+			p.Templates = append(p.Templates, m...)
+		}
+		sort.Strings(p.Templates)
+		if len(p.Templates) == 0 {
+			return nil, fmt.Errorf("%s: no templates found (expected \"templates\" to be set or templates/*.{yml,yaml} to exist)", manifest)
+		}
+	} else {
+		p.Templates = p.resolve(p.Templates)
+	}
+	p.Lib = p.resolve(p.Lib)
+	if p.Defaults == nil {
+		if _, err := os.Stat(filepath.Join(abs, "defaults.yaml")); err == nil {
+			p.Defaults = []string{"defaults.yaml"}
+		}
+	}
+	p.Defaults = p.resolve(p.Defaults)
+	for env, files := range p.Envs {
+		p.Envs[env] = p.resolve(files)
+	}
+	return &p, nil
+}
+
+func (p *Package) resolve(paths []string) []string {
+	var r []string
+	for _, path := range paths {
+		if !filepath.IsAbs(path) && !isURL(path) {
+			path = filepath.Join(p.Dir, path)
+		}
+		r = append(r, path)
+	}
+	return r
+}
+
+// files returns all the (local) files/directories package refers to (templates, lib, defaults and env config files).
+func (p *Package) files() []string {
+	r := append(append(append([]string{}, p.Templates...), p.Lib...), p.Defaults...)
+	var envs []string
+	for env := range p.Envs {
+		envs = append(envs, env)
+	}
+	sort.Strings(envs)
+	for _, env := range envs {
+		r = append(r, p.Envs[env]...)
+	}
+	var local []string
+	for _, file := range r {
+		if !isURL(file) {
+			local = append(local, file)
+		}
+	}
+	return local
+}
+
+// ConfigFiles returns Defaults followed by config file(s) of a given env ("" means no env).
+func (p *Package) ConfigFiles(env string) ([]string, error) {
+	files := append([]string{}, p.Defaults...)
+	if env == "" {
+		return files, nil
+	}
+	if envFiles, ok := p.Envs[env]; ok {
+		return append(files, envFiles...), nil
+	}
+	for _, ext := range []string{".env", ".yml", ".yaml", ".json"} {
+		file := filepath.Join(p.Dir, "envs", env+ext)
+		if _, err := os.Stat(file); err == nil {
+			return append(files, file), nil
+		}
+	}
+	var known []string
+	for name := range p.Envs {
+		known = append(known, name)
+	}
+	if m, err := filepath.Glob(filepath.Join(p.Dir, "envs", "*")); err == nil {
+		for _, file := range m {
+			name := filepath.Base(file)
+			known = append(known, name[:len(name)-len(filepath.Ext(name))])
+		}
+	}
+	sort.Strings(known)
+	return nil, fmt.Errorf(`%s: env "%s" not found (known: %v)`, filepath.Join(p.Dir, PackageManifest), env, known)
+}
+
+// ApplyDefaults sets variables that aren't present in config to their (declared) defaults.
+func (p *Package) ApplyDefaults(config map[string]interface{}) {
+	for _, v := range p.Vars {
+		if _, ok := config[v.Name]; !ok && v.Default != nil {
+			config[v.Name] = v.Default
+		}
+	}
+}
+
+// Validate checks that all required variables are set and have the declared type.
+func (p *Package) Validate(config map[string]interface{}) error {
+	manifest := filepath.Join(p.Dir, PackageManifest)
+	var errs engine.Errors
+	for _, v := range p.Vars {
+		value, ok := config[v.Name]
+		if !ok {
+			if v.Required {
+				msg := fmt.Sprintf(`"%s" isn't set`, v.Name)
+				if v.Description != "" {
+					msg += " (" + v.Description + ")"
+				}
+				errs = append(errs, &Error{Source: manifest, Err: fmt.Errorf("%s", msg)})
+			}
+			continue
+		}
+		if err := engine.ValidateParameterType(v.Name, v.Type, value); err != nil {
+			errs = append(errs, &Error{Source: manifest, Err: err})
+		}
+	}
+	if errs != nil {
+		return errs
+	}
+	return nil
+}
+
+// OpenPackage returns Renderer (configured with opts) and the list of templates args resolve to (see Renderer.Open).
+func OpenPackage(args []string, env string, opts Options) (*Renderer, []string, error) {
+	r := New(opts)
+	templates, err := r.Open(args, env)
+	if err != nil {
+		return nil, nil, err
+	}
+	return r, templates, nil
+}
+
+// Open returns the list of templates args resolve to.
+// If args is a single package directory, templates are the ones package consists of, package syntax is used
+// (unless Syntax is set), package lib directories are prepended to Lib and package defaults/env config
+// file(s) (see ConfigFiles) are applied by ReadConfig (env must be empty otherwise).
+// Package manifest is read with ReadFile (if set) and (if Chroot is set) everything package refers to must be
+// within Chroot. Open is meant to be called once (before Renderer is used).
+func (r *Renderer) Open(args []string, env string) ([]string, error) {
+	if len(args) == 1 && IsPackage(args[0]) {
+		pkg, err := loadPackage(args[0], r.readFile)
+		if err != nil {
+			return nil, err
+		}
+		if r.Chroot != "" {
+			chroot, err := filepath.Abs(r.Chroot)
+			if err != nil {
+				return nil, err
+			}
+			for _, file := range pkg.files() {
+				if !isWithin(filepath.Clean(file), []string{chroot}) {
+					return nil, fmt.Errorf("%s: %s is outside of --chroot %s",
+						filepath.Join(pkg.Dir, PackageManifest), file, r.Chroot)
+				}
+			}
+		}
+		if _, err := pkg.ConfigFiles(env); err != nil {
+			return nil, err
+		}
+		if r.Syntax == "" {
+			r.Syntax = pkg.Syntax
+		}
+		r.Lib = append(append([]string{}, pkg.Lib...), r.Lib...)
+		r.pkg, r.env = pkg, env
+		return pkg.Templates, nil
+	}
+	for _, arg := range args {
+		if IsPackage(arg) {
+			return nil, fmt.Errorf("%s: package cannot be combined with other templates/packages", arg)
+		}
+	}
+	if env != "" {
+		return nil, fmt.Errorf("--env can only be used with a package (directory containing %s)", PackageManifest)
+	}
+	return args, nil
+}
+
+// ReadConfig reads package defaults and env config file(s) (if Renderer was opened with a package (see Open))
+// followed by configFiles, merges env (environment variables (see ReadEnv)) on top, passes the result to override
+// (e.g. to apply -s/--set) and then fills in declared package variable defaults.
+// Keys coming from package defaults (both config file(s) and declared variables) and env are not reported as
+// unused by Check (and, consequently, do not fail Render when FailOnUnknownKeys is set).
+func (r *Renderer) ReadConfig(configFiles []string, env map[string]interface{},
+	override func(config map[string]interface{}) error) (map[string]interface{}, error) {
+	r.implicitKeys = make(map[string]bool)
+	var defaults []string
+	if r.pkg != nil {
+		files, err := r.pkg.ConfigFiles(r.env)
+		if err != nil {
+			return nil, err
+		}
+		defaults = r.pkg.Defaults
+		configFiles = append(files, configFiles...)
+	}
+	config := make(map[string]interface{})
+	for i, path := range configFiles {
+		cfg, err := r.readConfigFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if i < len(defaults) {
+			r.addImplicitKeys(cfg)
+		}
+		if err := r.MergeConfig(config, cfg); err != nil {
+			return nil, err
+		}
+	}
+	r.addImplicitKeys(env)
+	if err := r.MergeConfig(config, env); err != nil {
+		return nil, err
+	}
+	if override != nil {
+		if err := override(config); err != nil {
+			return nil, err
+		}
+	}
+	if r.pkg != nil {
+		for _, v := range r.pkg.Vars {
+			r.implicitKeys[v.Name] = true
+		}
+		r.pkg.ApplyDefaults(config)
+	}
+	return config, nil
+}
+
+func (r *Renderer) addImplicitKeys(config map[string]interface{}) {
+	for key := range config {
+		r.implicitKeys[key] = true
+	}
+}
+
+// ValidateConfig checks config against package variables (see Package.Validate)
+// (no-op unless Renderer was opened with a package).
+func (r *Renderer) ValidateConfig(config map[string]interface{}) error {
+	if r.pkg == nil {
+		return nil
+	}
+	return r.pkg.Validate(config)
+}
@@ -0,0 +1,470 @@
+// Package render implements template rendering pipeline used by kubetpl
+// (template flavor detection, "kubetpl/data-from-file" injection, ConfigMap/Secret freezing and output).
+package render
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/shyiko/kubetpl/engine"
+	"github.com/shyiko/kubetpl/engine/processor"
+	yamlext "github.com/shyiko/kubetpl/yaml"
+	"gopkg.in/yaml.v2"
+	yamlv3 "gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Options controls how templates are rendered.
+type Options struct {
+	// Template flavor ("$", "go-template" or "template-kind").
+	// Ignored for templates containing "# kubetpl:syntax:<flavor>" directive.
+	Syntax string
+	// The root directory in which extensions like "kubetpl/data-from-file" are allowed to read files.
+	Chroot string
+	// Shorthand for Chroot=<directory containing template> (has no effect if Chroot is set).
+	ChrootTemplateDir bool
+	// Freeze ConfigMap/Secret|s.
+	Freeze bool
+	// External ConfigMap/Secret|s that should not be included in the output and yet references to which need to
+	// be frozen.
+	FreezeRefs []string
+	// <kind>/<name>s to freeze (e.g. ConfigMap/foo, Secret/bar).
+	FreezeList []string
+	// Additional places ConfigMap/Secret|s can be referenced from ("<kind>[.<group>]:<path>[:<ConfigMap|Secret>]",
+	// e.g. "Rollout.argoproj.io:spec.template.spec.volumes[*].configMap.name") (see processor.ParseFreezePath).
+	FreezePaths []string
+	// Files containing FreezePaths (see processor.ParseFreezePaths).
+	FreezePathsFiles []string
+	// What frozen ConfigMap/Secret's hash is computed from: "object" (default), "data" (data/binaryData/stringData
+	// only) or "kustomize" (same hash kustomize's configMapGenerator/secretGenerator would produce).
+	FreezeHash string
+	// Number of characters of the hash to keep (7 by default, 10 in case of "kustomize" (cannot be changed)).
+	FreezeHashLength int
+	// Name of the frozen ConfigMap/Secret ("{{name}}-{{hash}}" by default).
+	FreezeNameTemplate string
+	// Keep $VAR/${VAR} if not set ("$" flavor only).
+	IgnoreUnset bool
+	// Substitute variables within YAML scalars only, quoting/escaping values as needed ("$" flavor only).
+	NodeAware bool
+	// Directories to look for "# kubetpl:include:<path>"s in (after the directory of the template) and partials
+	// available to go-template templates via {{ template "<path>" . }}/{{ include "<path>" . }}.
+	Lib []string
+	// How lists are merged when the same key is present in more than one config file (ListMergeReplace (default),
+	// ListMergeAppend or ListMergeByKey).
+	ListMerge string
+	// Key ListMergeByKey matches list items by (DefaultListMergeKey if empty).
+	ListMergeKey string
+	// Fail if config contains (top-level) keys that aren't referenced by any of the templates (or FreezeRefs).
+	FailOnUnknownKeys bool
+	// Expand ${KEY}/$KEY references within *.env files (both config files and "kubetpl/data-from-env-file" entries)
+	// (KEYs are resolved against variables defined earlier in the same file and then environment variables).
+	DotEnvInterpolate bool
+}
+
+// Renderer renders templates according to Options.
+type Renderer struct {
+	Options
+	// ReadFile is used to load templates, config files, --freeze-ref|s and "kubetpl/data-from-file" entries
+	// (ReadFile (package-level) is used if nil).
+	ReadFile func(path string) ([]byte, error)
+	// ReadDir is used to list directories given in place of templates (ioutil.ReadDir is used if nil).
+	ReadDir func(dir string) ([]os.FileInfo, error)
+	// package (and env) Renderer was opened with (see Open)
+	pkg *Package
+	env string
+	// top-level config keys that came from package defaults or environment variables (see ReadConfig)
+	implicitKeys map[string]bool
+	// config files read so far (absolute paths) (excluded from directories given in place of templates)
+	configFiles map[string]bool
+}
+
+// Document is a single (YAML) document produced by a template.
+type Document struct {
+	// Template the document was rendered from.
+	Source string
+	// Index of the document within the Source.
+	Index  int
+	Header []byte
+	Object map[interface{}]interface{}
+	// Node is the Object as it appears in the template (key order, comments, etc).
+	// Changes made to the Object are reflected in the output (nil if document couldn't be parsed by
+	// gopkg.in/yaml.v3, in which case Object is serialized as is).
+	Node   *yamlv3.Node
+	Footer []byte
+}
+
+// Kind returns value of the "kind" (or "" if not set).
+func (d Document) Kind() string {
+	kind, _ := d.Object["kind"].(string)
+	return kind
+}
+
+// Name returns value of the "metadata.name" (or "" if not set).
+func (d Document) Name() string {
+	meta, _ := d.Object["metadata"].(map[interface{}]interface{})
+	name, _ := meta["name"].(string)
+	return name
+}
+
+// Result of Renderer.Render.
+type Result struct {
+	Documents []Document
+}
+
+// Objects returns a slice of rendered objects (empty documents excluded).
+func (r *Result) Objects() []map[interface{}]interface{} {
+	var objs []map[interface{}]interface{}
+	for _, doc := range r.Documents {
+		if len(doc.Object) != 0 {
+			objs = append(objs, doc.Object)
+		}
+	}
+	return objs
+}
+
+// Bytes returns "---"-separated YAML stream.
+// Key order and comments (except for "# kubetpl:" directives) are preserved.
+func (r *Result) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	for _, doc := range r.Documents {
+		if len(doc.Object) == 0 {
+			continue
+		}
+		o, err := doc.marshal()
+		if err != nil {
+			return nil, err
+		}
+		buf.Write([]byte("---\n"))
+		buf.Write(o)
+	}
+	return buf.Bytes(), nil
+}
+
+func (d Document) marshal() ([]byte, error) {
+	if d.Node == nil {
+		return yaml.Marshal(d.Object)
+	}
+	yamlext.SyncNode(d.Node, d.Object)
+	o, err := yamlext.MarshalNode(d.Node)
+	if err != nil {
+		return nil, err
+	}
+	lines := bytes.SplitAfter(o, []byte("\n"))
+	// trailing comments (e.g. "# kubesec:" footer) are attached to the last key by yaml.v3 (and thus indented),
+	// which is why they are written as they appear in the template
+	var footer [][]byte
+	if len(d.Footer) != 0 {
+		footer = bytes.Split(d.Footer, []byte("\n"))
+		i, j := len(lines)-1, len(footer)-1
+		for i >= 0 && j >= 0 {
+			switch {
+			case len(bytes.TrimSpace(lines[i])) == 0:
+				i--
+			case len(bytes.TrimSpace(footer[j])) == 0:
+				j--
+			case bytes.Equal(bytes.TrimSpace(lines[i]), bytes.TrimSpace(footer[j])):
+				i--
+				j--
+			default:
+				i, j = -1, 0 // mismatch
+			}
+		}
+		if j < 0 {
+			lines = lines[:i+1]
+		} else {
+			footer = nil
+		}
+	}
+	var buf bytes.Buffer
+	for _, line := range append(lines, footer...) {
+		if bytes.HasPrefix(line, []byte("# kubetpl:")) ||
+			(buf.Len() == 0 && len(bytes.TrimSpace(line)) == 0) {
+			continue
+		}
+		buf.Write(line)
+		if !bytes.HasSuffix(line, []byte("\n")) && len(line) != 0 {
+			buf.Write([]byte("\n"))
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+const (
+	// "---"-separated YAML documents (default)
+	FormatYAML = "yaml"
+	// JSON objects (one after another, e.g. for "jq" / "kubectl apply -f -")
+	FormatJSON = "json"
+	// JSON objects (one per line)
+	FormatJSONLines = "json-lines"
+	// a single (JSON-encoded) "kind: List" object
+	FormatList = "list"
+)
+
+// Encode returns rendered objects in a given format (FormatYAML, FormatJSON, FormatJSONLines or FormatList).
+func (r *Result) Encode(format string) ([]byte, error) {
+	switch format {
+	case FormatYAML, "":
+		return r.Bytes()
+	case FormatJSON, FormatJSONLines:
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		if format == FormatJSON {
+			enc.SetIndent("", "  ")
+		}
+		for _, obj := range r.Objects() {
+			if err := enc.Encode(yamlext.JSONCompatible(obj)); err != nil {
+				return nil, err
+			}
+		}
+		return buf.Bytes(), nil
+	case FormatList:
+		items := make([]interface{}, 0)
+		for _, obj := range r.Objects() {
+			items = append(items, yamlext.JSONCompatible(obj))
+		}
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "List",
+			"items":      items,
+		}); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	return nil, fmt.Errorf(`Unknown output format "%s" (expected yaml, json, json-lines or list)`, format)
+}
+
+// New returns a Renderer configured with opts.
+func New(opts Options) *Renderer {
+	return &Renderer{Options: opts}
+}
+
+// Render renders templateFiles (see ExpandTemplateFiles) using config (in the order given) and then,
+// if requested, freezes the result.
+func (r *Renderer) Render(templateFiles []string, config map[string]interface{}) (*Result, error) {
+	if r.FailOnUnknownKeys {
+		report, err := r.Check(append(append([]string{}, templateFiles...), r.FreezeRefs...), config)
+		if err != nil {
+			return nil, err
+		}
+		if len(report.Unused) != 0 {
+			var errs engine.Errors
+			for _, p := range report.Unused {
+				errs = append(errs, fmt.Errorf("%s", p.String()))
+			}
+			return nil, errs
+		}
+	}
+	docs, err := r.renderTemplates(templateFiles, config)
+	if err != nil {
+		return nil, err
+	}
+	if r.Freeze || len(r.FreezeRefs) > 0 || len(r.FreezeList) > 0 {
+		refs, err := r.renderTemplates(r.FreezeRefs, config)
+		if err != nil {
+			return nil, err
+		}
+		paths, err := r.freezePaths()
+		if err != nil {
+			return nil, err
+		}
+		if err := processor.FreezeInPlace(processor.FreezeRequest{
+			Docs:         bodySlice(docs),
+			Refs:         bodySlice(refs),
+			Include:      r.FreezeList,
+			Paths:        paths,
+			Hash:         r.FreezeHash,
+			HashLength:   r.FreezeHashLength,
+			NameTemplate: r.FreezeNameTemplate,
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return &Result{Documents: docs}, nil
+}
+
+func (r *Renderer) freezePaths() ([]processor.FreezePath, error) {
+	var paths []processor.FreezePath
+	for _, file := range r.FreezePathsFiles {
+		data, err := r.readFile(file)
+		if err != nil {
+			return nil, err
+		}
+		p, err := processor.ParseFreezePaths(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", file, err.Error())
+		}
+		paths = append(paths, p...)
+	}
+	for _, s := range r.FreezePaths {
+		p, err := processor.ParseFreezePath(s)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, p)
+	}
+	return paths, nil
+}
+
+func bodySlice(docs []Document) []map[interface{}]interface{} {
+	var r []map[interface{}]interface{}
+	for _, doc := range docs {
+		r = append(r, doc.Object)
+	}
+	return r
+}
+
+func (r *Renderer) readFile(path string) ([]byte, error) {
+	if r.ReadFile != nil {
+		return r.ReadFile(path)
+	}
+	return ReadFile(path)
+}
+
+func (r *Renderer) renderTemplates(templateFiles []string, config map[string]interface{}) ([]Document, error) {
+	templateFiles, err := r.ExpandTemplateFiles(templateFiles)
+	if err != nil {
+		return nil, err
+	}
+	chroot := r.Chroot
+	if chroot != "" {
+		chroot, err = filepath.Abs(chroot)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var docs []Document
+	var errs engine.Errors
+	for _, templateFile := range templateFiles {
+		d, err := r.renderTemplate(templateFile, config, chroot)
+		if err != nil {
+			// keep going to report all problems (across all templates) at once
+			if e, ok := err.(engine.Errors); ok {
+				for _, err := range e {
+					if _, ok := err.(*Error); !ok { // (errors within included files already have Source set)
+						err = &Error{Source: templateFile, Err: err}
+					}
+					errs = append(errs, err)
+				}
+			} else if strings.HasPrefix(err.Error(), templateFile) {
+				errs = append(errs, err) // (already mentions the template)
+			} else {
+				errs = append(errs, &Error{Source: templateFile, Err: err})
+			}
+			continue
+		}
+		docs = append(docs, d...)
+	}
+	if errs != nil {
+		return nil, errs
+	}
+	return docs, nil
+}
+
+// Error is an error that occurred while rendering a specific template.
+type Error struct {
+	Source string
+	Err    error
+}
+
+func (e *Error) Error() string {
+	if ve, ok := e.Err.(*engine.VarError); ok && ve.Line != 0 {
+		return e.Source + ":" + ve.Error()
+	}
+	return e.Source + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (r *Renderer) renderTemplate(templateFile string, config map[string]interface{}, chroot string) ([]Document, error) {
+	t, _, directives, lines, err := r.newTemplate(templateFile)
+	if err != nil {
+		return nil, err
+	}
+	data := make(map[string]interface{})
+	for _, d := range directives {
+		if d.Key == DirectiveSet {
+			split := strings.SplitN(d.Value, "=", 2)
+			data[split[0]] = split[1]
+		}
+	}
+	for k, v := range config {
+		data[k] = v
+	}
+	out, err := t.Render(data)
+	if err != nil {
+		return nil, lines.remapError(templateFile, err)
+	}
+	baseDir, err := dirnameAbs(templateFile)
+	if err != nil {
+		return nil, err
+	}
+	templateChroot := chroot
+	if chroot == "" && r.ChrootTemplateDir {
+		templateChroot = baseDir
+	}
+	if templateChroot != "" && !strings.HasSuffix(templateChroot, string(filepath.Separator)) {
+		templateChroot += string(filepath.Separator)
+	}
+	var docs []Document
+	for i, chunk := range yamlext.Chunk(out) {
+		obj := make(map[interface{}]interface{})
+		if err = yaml.Unmarshal(chunk, &obj); err != nil {
+			return nil, err
+		}
+		if _, err := processor.ReplaceDataFromFileInPlace(obj, func(path string) (string, []byte, error) {
+			file := path
+			if !filepath.IsAbs(file) {
+				file = filepath.Join(baseDir, file)
+			}
+			file, err := filepath.Abs(file)
+			if err != nil {
+				return "", nil, err
+			}
+			if templateChroot == "" || !strings.HasPrefix(file, templateChroot) {
+				fileRel := file
+				if cwd, err := os.Getwd(); err == nil {
+					if p, err := filepath.Rel(cwd, file); err == nil {
+						fileRel = p
+					}
+				}
+				return "", nil, fmt.Errorf(`%s: access denied: %s`+
+					" (use --allow-fs-access and/or -c/--chroot=<root dir, e.g. '.'> to allow)",
+					templateFile, fileRel)
+			}
+			data, err := r.readFile(file)
+			return filepath.Base(file), data, err
+		}, r.dotEnvOptions()...); err != nil {
+			return nil, err
+		}
+		node, err := yamlext.ParseNode(chunk)
+		if err != nil {
+			node = nil // fallback to yaml.v2
+		}
+		docs = append(docs, Document{
+			Source: templateFile,
+			Index:  i,
+			Header: yamlext.Header(chunk),
+			Object: obj,
+			Node:   node,
+			Footer: yamlext.Footer(chunk),
+		})
+	}
+	return docs, nil
+}
+
+func dirnameAbs(path string) (string, error) {
+	if path == "-" {
+		return os.Getwd()
+	}
+	return filepath.Abs(filepath.Dir(path))
+}
@@ -0,0 +1,1503 @@
+package render
+
+import (
+	"encoding/json"
+	"fmt"
+	yamlext "github.com/shyiko/kubetpl/yaml"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func render(templateFiles []string, config map[string]interface{}, opts Options) ([]byte, error) {
+	res, err := New(opts).Render(templateFiles, config)
+	if err != nil {
+		return nil, err
+	}
+	return res.Bytes()
+}
+
+func TestRender(t *testing.T) {
+	cfg := map[string]interface{}{
+		"NAME":    "nm",
+		"MESSAGE": "msg",
+	}
+	opts := Options{}
+	renderedSh, err := render([]string{"../example/nginx.$.yml"}, cfg, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(renderedSh) == 0 {
+		t.Fatal("len(rendered) == 0")
+	}
+	renderedGo, err := render([]string{"../example/nginx.go-template.yml"}, cfg, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	renderedTk, err := render([]string{"../example/nginx.template-kind.yml"}, cfg, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// (formatting (e.g. quoting) differs between flavors, objects themselves must not)
+	if !reflect.DeepEqual(unmarshalAll(t, renderedSh), unmarshalAll(t, renderedGo)) {
+		t.Fatalf("sh: \n%s != go: \n%s", string(renderedSh), string(renderedGo))
+	}
+	if !reflect.DeepEqual(unmarshalAll(t, renderedGo), unmarshalAll(t, renderedTk)) {
+		t.Fatalf("go: \n%s != tk: \n%s", string(renderedGo), string(renderedTk))
+	}
+}
+
+func unmarshalAll(t *testing.T, data []byte) []map[interface{}]interface{} {
+	var r []map[interface{}]interface{}
+	for _, chunk := range yamlext.Chunk(data) {
+		obj := make(map[interface{}]interface{})
+		if err := yaml.Unmarshal(chunk, &obj); err != nil {
+			t.Fatal(err)
+		}
+		r = append(r, obj)
+	}
+	return r
+}
+
+func TestRenderWithDataFromFile(t *testing.T) {
+	// todo: test secret ("data" must be base64-encoded)
+	src := []string{"../example/nginx-with-data-from-file.yml"}
+	cfg := map[string]interface{}{
+		"NAME": "app",
+	}
+	if _, err := render(src, cfg, Options{}); err == nil {
+		t.FailNow()
+	}
+	cwd, err := filepath.Abs(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := render(src, cfg, Options{Chroot: "../vendor"}); err == nil {
+		t.FailNow()
+	}
+	if _, err := render(src, cfg, Options{Chroot: filepath.Join(cwd, "../vendor")}); err == nil {
+		t.FailNow()
+	}
+	if _, err := render(src, cfg, Options{Chroot: "../example"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := render(src, cfg, Options{Chroot: filepath.Join(cwd, "../example")}); err != nil {
+		t.Fatal(err)
+	}
+	actual, err := render(src, cfg, Options{ChrootTemplateDir: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := ioutil.ReadFile("../example/nginx-with-data-from-file.rendered.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// (key order is covered by TestRenderPreservesKeyOrderAndComments)
+	if !reflect.DeepEqual(unmarshalAll(t, actual), unmarshalAll(t, expected)) {
+		t.Fatalf("actual: \n%s != expected: \n%s", actual, expected)
+	}
+}
+
+func TestFreeze(t *testing.T) {
+	config := map[string]interface{}{
+		"NAME":    "app",
+		"MESSAGE": "msg",
+	}
+	actual, err := render([]string{"../example/nginx-with-data-from-file.yml"}, config,
+		Options{Freeze: true, ChrootTemplateDir: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := ioutil.ReadFile("../example/nginx-with-data-from-file.rendered+frozen.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// (key order is covered by TestRenderPreservesKeyOrderAndComments)
+	if !reflect.DeepEqual(unmarshalAll(t, actual), unmarshalAll(t, expected)) {
+		t.Fatalf("actual: \n%s != expected: \n%s", actual, expected)
+	}
+}
+
+func TestFreezeExternal(t *testing.T) {
+	tmplFile, err := ioutil.TempFile("", "kubetpl-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := `
+apiVersion: v1
+kind: Pod
+metadata:
+  name: app
+spec:
+  volumes:
+  - name: app-volume
+    configMap:
+      name: app
+`
+	if err := ioutil.WriteFile(tmplFile.Name(), []byte(src), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config := map[string]interface{}{
+		"NAME":    "app",
+		"MESSAGE": "msg",
+	}
+	actual, err := render([]string{tmplFile.Name()}, config, Options{
+		FreezeRefs: []string{"../example/nginx.$.yml"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: app
+spec:
+  volumes:
+  - name: app-volume
+    configMap:
+      name: app-984c62c
+`
+	if string(actual) != string(expected) {
+		t.Fatalf("actual: \n%s != expected: \n%s", actual, expected)
+	}
+}
+
+func TestFreezeBuiltInPaths(t *testing.T) {
+	refs := `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app
+data:
+  key: value
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: app
+data:
+  key: dmFsdWU=
+---
+`
+	podSpec := `
+  initContainers:
+  - name: init
+    env:
+    - name: A
+      valueFrom:
+        configMapKeyRef:
+          name: app
+          key: key
+  containers:
+  - name: web
+    envFrom:
+    - configMapRef:
+        name: app
+    - secretRef:
+        name: app
+  ephemeralContainers:
+  - name: debug
+    env:
+    - name: B
+      valueFrom:
+        secretKeyRef:
+          name: app
+          key: key
+  imagePullSecrets:
+  - name: app
+  volumes:
+  - name: projected
+    projected:
+      sources:
+      - configMap:
+          name: app
+      - secret:
+          name: app
+  - name: csi
+    csi:
+      driver: secrets-store.csi.k8s.io
+      nodePublishSecretRef:
+        name: app
+`
+	indent := func(s string, prefix string) string {
+		return strings.Replace(s, "\n  ", "\n  "+prefix, -1)
+	}
+	for _, test := range []struct {
+		kind string
+		src  string
+		refs int
+	}{
+		{"Pod", "apiVersion: v1\nkind: Pod\nmetadata:\n  name: web\nspec:" + podSpec, 8},
+		{"PodTemplate", "apiVersion: v1\nkind: PodTemplate\nmetadata:\n  name: web\ntemplate:\n  spec:" +
+			indent(podSpec, "  "), 8},
+		{"DaemonSet", "apiVersion: apps/v1\nkind: DaemonSet\nmetadata:\n  name: web\nspec:\n  template:\n    spec:" +
+			indent(podSpec, "    "), 8},
+		{"Deployment", "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: web\nspec:\n  template:\n    spec:" +
+			indent(podSpec, "    "), 8},
+		{"Job", "apiVersion: batch/v1\nkind: Job\nmetadata:\n  name: web\nspec:\n  template:\n    spec:" +
+			indent(podSpec, "    "), 8},
+		{"ReplicaSet", "apiVersion: apps/v1\nkind: ReplicaSet\nmetadata:\n  name: web\nspec:\n  template:\n    spec:" +
+			indent(podSpec, "    "), 8},
+		{"ReplicationController", "apiVersion: v1\nkind: ReplicationController\nmetadata:\n  name: web\nspec:\n  template:\n    spec:" +
+			indent(podSpec, "    "), 8},
+		{"StatefulSet", "apiVersion: apps/v1\nkind: StatefulSet\nmetadata:\n  name: web\nspec:\n  template:\n    spec:" +
+			indent(podSpec, "    "), 8},
+		{"CronJob", "apiVersion: batch/v1beta1\nkind: CronJob\nmetadata:\n  name: web\nspec:\n  jobTemplate:\n    spec:\n" +
+			"      template:\n        spec:" + indent(podSpec, "        "), 8},
+		{"PodPreset", `apiVersion: settings.k8s.io/v1alpha1
+kind: PodPreset
+metadata:
+  name: web
+spec:
+  envFrom:
+  - configMapRef:
+      name: app
+  volumes:
+  - name: projected
+    projected:
+      sources:
+      - secret:
+          name: app
+`, 2},
+		{"Ingress", `apiVersion: networking.k8s.io/v1beta1
+kind: Ingress
+metadata:
+  name: web
+spec:
+  tls:
+  - hosts:
+    - example.com
+    secretName: app
+`, 1},
+		{"ServiceAccount", `apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: web
+secrets:
+- name: app
+imagePullSecrets:
+- name: app
+`, 2},
+	} {
+		tmplFile, err := ioutil.TempFile("", "kubetpl-test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(tmplFile.Name())
+		if err := ioutil.WriteFile(tmplFile.Name(), []byte(refs+test.src), 0600); err != nil {
+			t.Fatal(err)
+		}
+		actual, err := render([]string{tmplFile.Name()}, nil, Options{Freeze: true})
+		if err != nil {
+			t.Fatalf("%s: %s", test.kind, err.Error())
+		}
+		out := string(actual)
+		count := strings.Count(out, ": app-ac7478b\n") + strings.Count(out, ": app-2c9b4df\n")
+		if strings.Contains(out, ": app\n") || count != test.refs+2 {
+			t.Fatalf("%s: expected %d reference(s) to be rewritten, instead got: \n%s", test.kind, test.refs, out)
+		}
+	}
+}
+
+func TestFreezeIgnoresExternalSecrets(t *testing.T) {
+	tmplFile, err := ioutil.TempFile("", "kubetpl-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmplFile.Name())
+	src := `
+apiVersion: v1
+kind: Secret
+metadata:
+  name: app
+data:
+  key: dmFsdWU=
+---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: web
+spec:
+  imagePullSecrets:
+  - name: registry
+---
+apiVersion: networking.k8s.io/v1beta1
+kind: Ingress
+metadata:
+  name: web
+spec:
+  tls:
+  - secretName: certificate
+---
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: web
+secrets:
+- name: web-token-abcde
+---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: csi
+spec:
+  volumes:
+  - name: csi
+    csi:
+      driver: secrets-store.csi.k8s.io
+      nodePublishSecretRef:
+        name: external-creds
+---
+apiVersion: batch/v1beta1
+kind: CronJob
+metadata:
+  name: job
+spec:
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          imagePullSecrets:
+          - name: cron-registry
+`
+	if err := ioutil.WriteFile(tmplFile.Name(), []byte(src), 0600); err != nil {
+		t.Fatal(err)
+	}
+	actual, err := render([]string{tmplFile.Name()}, nil, Options{Freeze: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"- name: registry\n", "- secretName: certificate\n", "- name: web-token-abcde\n",
+		"  name: external-creds\n", "- name: cron-registry\n"} {
+		if !strings.Contains(string(actual), expected) {
+			t.Fatalf("%s does not contain %s", actual, expected)
+		}
+	}
+}
+
+func TestFreezeRequiresRefsOfCustomPaths(t *testing.T) {
+	tmplFile, err := ioutil.TempFile("", "kubetpl-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmplFile.Name())
+	src := `
+apiVersion: v1
+kind: Secret
+metadata:
+  name: app
+data:
+  key: dmFsdWU=
+---
+apiVersion: example.com/v1
+kind: Foo
+metadata:
+  name: foo
+spec:
+  foo:
+    secrets:
+    - name: other
+`
+	if err := ioutil.WriteFile(tmplFile.Name(), []byte(src), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// (path ending the same way ServiceAccount's secrets[*].name does is not considered to be external)
+	_, err = render([]string{tmplFile.Name()}, nil, Options{Freeze: true,
+		FreezePaths: []string{"Foo:spec.foo.secrets[*].name:Secret"}})
+	if err == nil || !strings.Contains(err.Error(), "Stumbled upon unknown Secret/other reference (in Foo/foo)") {
+		t.Fatalf("expected Secret/other to require --freeze-ref, got %v", err)
+	}
+}
+
+func TestFreezeIsGroupAware(t *testing.T) {
+	tmplFile, err := ioutil.TempFile("", "kubetpl-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmplFile.Name())
+	deployment := func(apiVersion string, kind string, name string) string {
+		return "---\napiVersion: " + apiVersion + "\nkind: " + kind + "\nmetadata:\n  name: " + name + `
+spec:
+  template:
+    spec:
+      volumes:
+      - name: config
+        configMap:
+          name: app
+`
+	}
+	src := `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app
+data:
+  key: value
+---
+apiVersion: example.com/v1
+kind: ConfigMap
+metadata:
+  name: app
+data:
+  key: value
+` + deployment("apps/v1", "Deployment", "apps-v1") +
+		deployment("apps/v1beta1", "Deployment", "apps-v1beta1") +
+		deployment("extensions/v1beta1", "Deployment", "extensions-v1beta1") +
+		deployment("example.com/v1", "Deployment", "crd") +
+		deployment("example.com/v1", "Job", "crd") +
+		deployment("argoproj.io/v1alpha1", "Rollout", "argo") +
+		deployment("example.com/v1", "Rollout", "crd")
+	if err := ioutil.WriteFile(tmplFile.Name(), []byte(src), 0600); err != nil {
+		t.Fatal(err)
+	}
+	actual, err := render([]string{tmplFile.Name()}, nil, Options{
+		Freeze:      true,
+		FreezePaths: []string{"Rollout.argoproj.io:spec.template.spec.volumes[*].configMap.name"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	docs := strings.Split(string(actual), "---\n")[1:]
+	expected := []bool{true, false, true, true, true, false, false, true, false}
+	if len(docs) != len(expected) {
+		t.Fatalf("expected %d documents, instead got: \n%s", len(expected), actual)
+	}
+	for i, doc := range docs {
+		if frozen := strings.Contains(doc, ": app-ac7478b\n"); frozen != expected[i] {
+			t.Fatalf("document #%d: expected frozen to be %v, instead got: \n%s", i, expected[i], doc)
+		}
+	}
+}
+
+func TestFreezeNaming(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubetpl-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tmpl := func(name string, annotation string) string {
+		file := filepath.Join(dir, annotation+".yml")
+		ioutil.WriteFile(file, []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: `+name+`
+  annotations:
+    note: `+annotation+`
+data:
+  key: value
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: `+name+`
+type: Opaque
+data:
+  key: dmFsdWU=
+`), 0600)
+		return file
+	}
+	names := func(file string, opts Options) (string, string, error) {
+		opts.Freeze = true
+		actual, err := render([]string{file}, nil, opts)
+		if err != nil {
+			return "", "", err
+		}
+		m := regexp.MustCompile(`(?m)^  name: (.*)$`).FindAllStringSubmatch(string(actual), -1)
+		if len(m) != 2 {
+			t.Fatalf("unexpected output: \n%s", actual)
+		}
+		return m[0][1], m[1][1], nil
+	}
+	for _, test := range []struct {
+		opts       Options
+		configMap  *regexp.Regexp
+		annotation bool // whether name depends on annotations
+	}{
+		{Options{}, regexp.MustCompile(`^app-[0-9a-f]{7}$`), true},
+		{Options{FreezeHashLength: 12}, regexp.MustCompile(`^app-[0-9a-f]{12}$`), true},
+		{Options{FreezeHash: "data"}, regexp.MustCompile(`^app-[0-9a-f]{7}$`), false},
+		{Options{FreezeHash: "kustomize"}, regexp.MustCompile(`^app-9564tm62cm$`), false},
+		{Options{FreezeNameTemplate: "{{name}}.v{{hash}}", FreezeHashLength: 4}, regexp.MustCompile(`^app\.v[0-9a-f]{4}$`), true},
+	} {
+		cm1, _, err := names(tmpl("app", "a"), test.opts)
+		if err != nil {
+			t.Fatalf("%#v: %s", test.opts, err.Error())
+		}
+		cm2, _, err := names(tmpl("app", "b"), test.opts)
+		if err != nil {
+			t.Fatalf("%#v: %s", test.opts, err.Error())
+		}
+		if !test.configMap.MatchString(cm1) || (cm1 != cm2) == !test.annotation {
+			t.Fatalf("%#v: unexpected name(s) %s, %s", test.opts, cm1, cm2)
+		}
+	}
+	_, secret, err := names(tmpl("app", "a"), Options{FreezeHash: "kustomize"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret != "app-kd6k44c79g" {
+		t.Fatalf("actual: \n%s != expected: \n%s", secret, "app-kd6k44c79g")
+	}
+	for _, opts := range []Options{
+		{FreezeHash: "md5"},
+		{FreezeHashLength: 65},
+		{FreezeHashLength: -1},
+		{FreezeHash: "kustomize", FreezeHashLength: 7},
+		{FreezeNameTemplate: "{{name}}"},
+		{FreezeNameTemplate: "{{name}}-{{hash}}-{{kind}}"},
+	} {
+		if _, _, err := names(tmpl("app", "a"), opts); err == nil {
+			t.Fatalf("%#v: expected an error", opts)
+		}
+	}
+	if _, _, err := names(tmpl(strings.Repeat("a", 250), "a"), Options{}); err == nil ||
+		!strings.Contains(err.Error(), "longer than 253 characters") {
+		t.Fatalf("expected name length to be checked, instead got %v", err)
+	}
+}
+
+func TestFreezeIsExecutedLast(t *testing.T) {
+	dataFileDir, err := ioutil.TempDir("", "kubetpl-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataFile := filepath.Join(dataFileDir, "file.txt")
+	if err := ioutil.WriteFile(dataFile, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	tmplFile, err := ioutil.TempFile("", "kubetpl-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := `
+apiVersion: v1
+kind: ConfigMap
+data:
+  key: value
+metadata:
+  name: app
+kubetpl/data-from-file: DATA_FILE
+`
+	if err := ioutil.WriteFile(tmplFile.Name(), []byte(strings.Replace(src, "DATA_FILE", dataFile, 1)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	assertRenderedAs := func(expected string) {
+		actual, err := render([]string{tmplFile.Name()}, nil, Options{Freeze: true, ChrootTemplateDir: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(actual) != string(expected) {
+			t.Fatalf("actual: \n%s != expected: \n%s", actual, expected)
+		}
+	}
+	assertRenderedAs(`---
+apiVersion: v1
+kind: ConfigMap
+data:
+  key: value
+  file.txt: ""
+metadata:
+  name: app-fecea3a
+`)
+	if err := ioutil.WriteFile(dataFile, []byte("data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	assertRenderedAs(`---
+apiVersion: v1
+kind: ConfigMap
+data:
+  key: value
+  file.txt: data
+metadata:
+  name: app-caed0c7
+`)
+}
+
+func TestRenderPreservesKeyOrderAndComments(t *testing.T) {
+	tmplFile, err := ioutil.TempFile("", "kubetpl-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := `# kubetpl:syntax:$
+
+# comment
+kind: Secret
+apiVersion: v1
+metadata:
+  name: $NAME # name
+data:
+  key: dmFsdWU=
+# kubesec:v:3
+# kubesec:mac:0
+`
+	if err := ioutil.WriteFile(tmplFile.Name(), []byte(src), 0600); err != nil {
+		t.Fatal(err)
+	}
+	actual, err := render([]string{tmplFile.Name()}, map[string]interface{}{"NAME": "app"}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `---
+# comment
+kind: Secret
+apiVersion: v1
+metadata:
+  name: app # name
+data:
+  key: dmFsdWU=
+# kubesec:v:3
+# kubesec:mac:0
+`
+	if string(actual) != expected {
+		t.Fatalf("actual: \n%s != expected: \n%s", actual, expected)
+	}
+}
+
+func TestRenderTemplateKindPreservesKeyOrderAndStyle(t *testing.T) {
+	r := New(Options{})
+	r.ReadFile = func(path string) ([]byte, error) {
+		return []byte("# kubetpl:syntax:template-kind\nkind: Template\nobjects:\n- kind: ConfigMap\n  apiVersion: v1\n" +
+			"  metadata:\n    name: $(NAME) # name\n    ext/arr: [$((P)), a, $((P))]\nparameters:\n- name: NAME\n- name: P\n"), nil
+	}
+	res, err := r.Render([]string{"template.yml"}, map[string]interface{}{"NAME": "app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := res.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "---\nkind: ConfigMap\napiVersion: v1\nmetadata:\n  name: app # name\n  ext/arr: [a]\n"
+	if string(actual) != expected {
+		t.Fatalf("actual: \n%s != expected: \n%s", actual, expected)
+	}
+}
+
+func TestCRLFIsNormalizedToLF(t *testing.T) {
+	tmplFile, err := ioutil.TempFile("", "kubetpl-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(tmplFile.Name(), []byte("# kubetpl:syntax:$\r\nkind: ConfigMap\r\nmetadata:\r\n  name: $NAME"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := map[string]interface{}{
+		"NAME": "windows",
+	}
+	actual, err := render([]string{tmplFile.Name()}, cfg, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actual) == 0 {
+		t.Fatal("len(rendered) == 0")
+	}
+	expected := "---\nkind: ConfigMap\nmetadata:\n  name: windows\n"
+	if string(actual) != expected {
+		t.Fatalf("actual: \n%s != expected: \n%s", actual, expected)
+	}
+}
+
+func TestRenderWithCustomReadFile(t *testing.T) {
+	files := map[string]string{
+		"template.yml": "# kubetpl:syntax:$\nkind: ConfigMap\nmetadata:\n  name: $NAME\n---\nkind: Secret\nmetadata:\n  name: $NAME\n",
+		"config.env":   "NAME=app\n",
+	}
+	r := New(Options{})
+	r.ReadFile = func(path string) ([]byte, error) {
+		content, ok := files[path]
+		if !ok {
+			return nil, fmt.Errorf("%s not found", path)
+		}
+		return []byte(content), nil
+	}
+	config, err := r.ReadConfigFiles("config.env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := r.Render([]string{"template.yml"}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actual []string
+	for _, doc := range res.Documents {
+		actual = append(actual, fmt.Sprintf("%s#%d %s/%s", doc.Source, doc.Index, doc.Kind(), doc.Name()))
+	}
+	expected := []string{"template.yml#0 ConfigMap/app", "template.yml#1 Secret/app"}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("actual: \n%v != expected: \n%v", actual, expected)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	report, err := New(Options{}).Check(
+		[]string{"../example/nginx.$.yml", "../example/nginx.template-kind.yml"},
+		map[string]interface{}{"MESSAGE": "msg", "REPLICAS": "one", "NOT_USED": "value"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actual []string
+	for _, p := range append(append(report.Missing, report.Invalid...), report.Unused...) {
+		actual = append(actual, p.String())
+	}
+	expected := []string{
+		`../example/nginx.$.yml: "NAME" isn't set (6:9, 13:9, 19:14, 27:17, 30:15, 32:17)`,
+		`../example/nginx.template-kind.yml: "NAME" isn't set (15:11, 21:11, 27:16, 35:19, 38:17, 40:19)`,
+		`../example/nginx.template-kind.yml: "REPLICAS" must be a number (23:15)`,
+		`"NOT_USED" is not referenced by any of the templates`,
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("actual: \n%v != expected: \n%v", actual, expected)
+	}
+	if report.OK() {
+		t.Fatal("report.OK() == true")
+	}
+}
+
+func TestCheckReportsRequiredUsagesOnly(t *testing.T) {
+	r := New(Options{})
+	r.ReadFile = func(path string) ([]byte, error) {
+		return []byte("# kubetpl:syntax:$\nkind: ConfigMap\nmetadata:\n  name: ${NAME:-app}\ndata:\n  name: ${NAME}\n"), nil
+	}
+	report, err := r.Check([]string{"template.yml"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Missing) != 1 {
+		t.Fatalf("expected NAME to be reported as missing, instead got %v", report.Missing)
+	}
+	actual := report.Missing[0].String()
+	expected := `template.yml: "NAME" isn't set (6:9)`
+	if actual != expected {
+		t.Fatalf("actual: \n%s != expected: \n%s", actual, expected)
+	}
+}
+
+func TestRenderReportsAllUnset(t *testing.T) {
+	_, err := render([]string{"../example/nginx.$.yml", "../example/nginx.template-kind.yml"},
+		map[string]interface{}{"NAME": "app"}, Options{})
+	if err == nil {
+		t.Fatal()
+	}
+	expected := `../example/nginx.$.yml:8:16: "MESSAGE" isn't set
+../example/nginx.template-kind.yml:17:17: "MESSAGE" isn't set`
+	if err.Error() != expected {
+		t.Fatalf("actual: \n%s != expected: \n%s", err.Error(), expected)
+	}
+}
+
+func TestRenderReportsAllErrors(t *testing.T) {
+	files := map[string]string{
+		"a.yml": "# kubetpl:syntax:go-template\nkind: ConfigMap\nmetadata:\n  name: {{ index .LIST 5 }}\n",
+		"b.yml": "kind: ConfigMap\nmetadata:\n  name: app\nkubetpl/data-from-file:\n  - /etc/passwd\n",
+		"c.yml": "# kubetpl:syntax:$\nkind: ConfigMap\nmetadata:\n  name: $NAME\n",
+	}
+	r := New(Options{})
+	r.ReadFile = func(path string) ([]byte, error) {
+		content, ok := files[path]
+		if !ok {
+			return nil, fmt.Errorf("%s not found", path)
+		}
+		return []byte(content), nil
+	}
+	_, err := r.Render([]string{"a.yml", "b.yml", "c.yml"}, map[string]interface{}{"LIST": []interface{}{}})
+	if err == nil {
+		t.Fatal()
+	}
+	lines := strings.Split(err.Error(), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "a.yml: ") || !strings.HasPrefix(lines[1], "b.yml: access denied") ||
+		lines[2] != `c.yml:4:9: "NAME" isn't set` {
+		t.Fatalf("unexpected error: \n%s", err.Error())
+	}
+}
+
+func TestEncode(t *testing.T) {
+	res, err := New(Options{}).Render([]string{"../example/nginx.$.yml"},
+		map[string]interface{}{"NAME": "app", "MESSAGE": "<msg>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := res.Encode(FormatJSONLines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"apiVersion":"v1","data":{"index.html":"<msg>"},"kind":"ConfigMap","metadata":{"name":"app"}}
+{"apiVersion":"apps/v1beta1","kind":"Deployment","metadata":{"name":"app"},"spec":{"replicas":1,"template":{"metadata":{"labels":{"app":"app"}},"spec":{"containers":[{"image":"nginx:1.7.9","name":"nginx","ports":[{"containerPort":80}],"volumeMounts":[{"mountPath":"/usr/share/nginx/html","name":"app-volume"}]}],"volumes":[{"configMap":{"name":"app"},"name":"app-volume"}]}}}}
+`
+	if string(actual) != expected {
+		t.Fatalf("actual: \n%s != expected: \n%s", actual, expected)
+	}
+	list, err := res.Encode(FormatList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded struct {
+		APIVersion string                   `json:"apiVersion"`
+		Kind       string                   `json:"kind"`
+		Items      []map[string]interface{} `json:"items"`
+	}
+	if err := json.Unmarshal(list, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.APIVersion != "v1" || decoded.Kind != "List" || len(decoded.Items) != 2 {
+		t.Fatalf("%s", list)
+	}
+	if _, err := res.Encode("xml"); err == nil {
+		t.Fatal()
+	}
+}
+
+func TestInclude(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubetpl-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name string, content string) string {
+		file := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+	write("lib/labels.yml", "# kubetpl:syntax:$\napp: $NAME\n# kubetpl:include:tier.yml\n")
+	write("lib/tier.yml", "tier: web\n")
+	write("lib/probe.yml", "httpGet:\n  path: {{ .PATH }}\n{{- define \"port\" }}8080{{ end }}\n")
+	write("lib/README.md", "non-template files are not loaded as partials: {{ .\n")
+	write("secret.txt", "secret")
+	tmpl := write("svc/template.yml", "# kubetpl:syntax:$\nkind: Service\nmetadata:\n  name: $NAME\n  labels:\n"+
+		"    # kubetpl:include:labels.yml\n")
+	lib := []string{filepath.Join(dir, "lib")}
+	actual, err := render([]string{tmpl}, map[string]interface{}{"NAME": "app"}, Options{Lib: lib})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "---\nkind: Service\nmetadata:\n  name: app\n  labels:\n    app: app\n    tier: web\n"
+	if string(actual) != expected {
+		t.Fatalf("actual: \n%s != expected: \n%s", actual, expected)
+	}
+	if _, err := render([]string{tmpl}, map[string]interface{}{"NAME": "app"}, Options{}); err == nil {
+		t.Fatal("expected include to fail without --lib")
+	}
+	denied := write("svc/denied.yml", "# kubetpl:syntax:$\n# kubetpl:include:../secret.txt\nkind: Service\n")
+	if _, err := render([]string{denied}, nil, Options{Lib: lib}); err == nil ||
+		!strings.Contains(err.Error(), "access denied") {
+		t.Fatalf("expected access to be denied, got %v", err)
+	}
+	for _, tmpl := range []string{tmpl, denied} {
+		if _, err := render([]string{tmpl}, map[string]interface{}{"NAME": "app"},
+			Options{Lib: lib, Chroot: filepath.Join(dir, "svc")}); err == nil ||
+			!strings.Contains(err.Error(), "outside of --chroot") {
+			t.Fatalf("expected --lib outside of --chroot to be rejected, got %v", err)
+		}
+	}
+	write("lib/cycle.yml", "# kubetpl:include:cycle.yml\n")
+	cycle := write("svc/cycle.yml", "# kubetpl:syntax:$\n# kubetpl:include:cycle.yml\n")
+	if _, err := render([]string{cycle}, nil, Options{Lib: lib}); err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Fatalf("expected include cycle to be reported, got %v", err)
+	}
+	goTmpl := write("svc/go.yml", "# kubetpl:syntax:go-template\nkind: Pod\nspec:\n  containers:\n  - name: app\n"+
+		"    livenessProbe:\n{{ include \"probe.yml\" . | indent 6 }}\n    ports:\n    - containerPort: {{ template \"port\" }}\n")
+	actual, err = render([]string{goTmpl}, map[string]interface{}{"PATH": "/healthz"}, Options{Lib: lib})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = "---\nkind: Pod\nspec:\n  containers:\n  - name: app\n    livenessProbe:\n      httpGet:\n" +
+		"        path: /healthz\n    ports:\n    - containerPort: 8080\n"
+	if string(actual) != expected {
+		t.Fatalf("actual: \n%s != expected: \n%s", actual, expected)
+	}
+}
+
+func TestIncludePositions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubetpl-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	labels := filepath.Join(dir, "labels.yml")
+	ioutil.WriteFile(labels, []byte("app: app\ntier: web\nowner: $OWNER\n"), 0600)
+	tmpl := filepath.Join(dir, "template.yml")
+	ioutil.WriteFile(tmpl, []byte("# kubetpl:syntax:$\nmetadata:\n  labels:\n    # kubetpl:include:labels.yml\n"+
+		"  name: $NAME\n"), 0600)
+	_, err = render([]string{tmpl}, nil, Options{})
+	if err == nil {
+		t.Fatal()
+	}
+	expected := labels + `:3:8: "OWNER" isn't set
+` + tmpl + `:5:9: "NAME" isn't set`
+	if err.Error() != expected {
+		t.Fatalf("actual: \n%s != expected: \n%s", err.Error(), expected)
+	}
+	templates, err := New(Options{}).Vars([]string{tmpl})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actual []string
+	for _, v := range templates[0].Vars {
+		for _, u := range v.Usages {
+			actual = append(actual, v.Name+" "+u.String())
+		}
+	}
+	if !reflect.DeepEqual(actual, []string{"NAME 5:9", "OWNER " + labels + ":3:8"}) &&
+		!reflect.DeepEqual(actual, []string{"OWNER " + labels + ":3:8", "NAME 5:9"}) {
+		t.Fatal(actual)
+	}
+	goTmpl := filepath.Join(dir, "go.yml")
+	ioutil.WriteFile(filepath.Join(dir, "probe.yml"), []byte("path: {{ index .LIST 5 }}\n"), 0600)
+	ioutil.WriteFile(goTmpl, []byte("# kubetpl:syntax:go-template\nkind: Pod\nspec:\n  # kubetpl:include:probe.yml\n"), 0600)
+	_, err = render([]string{goTmpl}, map[string]interface{}{"LIST": []interface{}{}}, Options{})
+	if err == nil || !strings.Contains(err.Error(), "template: "+filepath.Join(dir, "probe.yml")+":1:9:") {
+		t.Fatalf("expected error to point to probe.yml:1:9, instead got %v", err)
+	}
+}
+
+func TestPackage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubetpl-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name string, content string) {
+		file := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(PackageManifest, "name: app\nsyntax: $\nvars:\n- name: NAME\n  required: true\n"+
+		"- name: REPLICAS\n  type: int\n  default: 1\n- name: IMAGE\n  required: true\n  description: container image\n")
+	write("defaults.yaml", "NAME: app\n")
+	write("envs/staging.env", "REPLICAS=2\n")
+	write("templates/deployment.yml", "kind: Deployment\nmetadata:\n  name: $NAME\nspec:\n  replicas: $REPLICAS\n"+
+		"  image: $IMAGE\n")
+	if IsPackage(filepath.Join(dir, "templates")) || !IsPackage(dir) {
+		t.Fatal("expected only directory containing kubetpl.yaml to be recognized as a package")
+	}
+	pkg, err := LoadPackage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pkg.ConfigFiles("prod"); err == nil || !strings.Contains(err.Error(), "[staging]") {
+		t.Fatalf("expected unknown env to be reported, got %v", err)
+	}
+	files, err := pkg.ConfigFiles("staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	renderer := New(Options{Syntax: pkg.Syntax})
+	config, err := renderer.ReadConfigFiles(files...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg.ApplyDefaults(config)
+	err = pkg.Validate(config)
+	if err == nil || !strings.Contains(err.Error(), `"IMAGE" isn't set (container image)`) {
+		t.Fatalf("expected IMAGE to be reported as missing, got %v", err)
+	}
+	config["IMAGE"] = "app:1"
+	if err := pkg.Validate(config); err != nil {
+		t.Fatal(err)
+	}
+	res, err := renderer.Render(pkg.Templates, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := res.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "---\nkind: Deployment\nmetadata:\n  name: app\nspec:\n  replicas: 2\n  image: app:1\n"
+	if string(actual) != expected {
+		t.Fatalf("actual: \n%s != expected: \n%s", actual, expected)
+	}
+	config["REPLICAS"] = "two"
+	if err := pkg.Validate(config); err == nil {
+		t.Fatal("expected REPLICAS type mismatch to be reported")
+	}
+	if _, _, err := OpenPackage([]string{dir}, "prod", Options{}); err == nil || !strings.Contains(err.Error(), "[staging]") {
+		t.Fatalf("expected unknown env to be reported, got %v", err)
+	}
+	if _, _, err := OpenPackage([]string{"deployment.yml"}, "staging", Options{}); err == nil {
+		t.Fatal("expected env to be rejected outside of a package")
+	}
+	renderer, templates, err := OpenPackage([]string{dir}, "staging", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err = renderer.ReadConfig(nil, nil, func(config map[string]interface{}) error {
+		config["IMAGE"] = "app:1"
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := renderer.ValidateConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	res, err = renderer.Render(templates, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err = res.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(actual) != expected {
+		t.Fatalf("actual: \n%s != expected: \n%s", actual, expected)
+	}
+}
+
+func TestPackageOpen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubetpl-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name string, content string) string {
+		file := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+	manifest := write("app/"+PackageManifest, "syntax: $\nvars:\n- name: REPLICAS\n  default: 1\n")
+	write("app/defaults.yaml", "NAME: app\nANNOTATION: x\n")
+	write("app/templates/service.yml", "kind: Service\nmetadata:\n  name: $NAME\n")
+	config := write("config.yml", "UNKNOWN: x\n")
+	var read []string
+	r := New(Options{FailOnUnknownKeys: true})
+	r.ReadFile = func(path string) ([]byte, error) {
+		read = append(read, path)
+		return ReadFile(path)
+	}
+	templates, err := r.Open([]string{filepath.Join(dir, "app")}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) == 0 || read[0] != manifest {
+		t.Fatalf("expected %s to be read with ReadFile, instead got %v", manifest, read)
+	}
+	cfg, err := r.ReadConfig(nil, map[string]interface{}{"HOME": "/root"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Render(templates, cfg); err != nil {
+		t.Fatalf("expected package defaults and environment variables not to be reported as unknown, got %v", err)
+	}
+	cfg, err = r.ReadConfig([]string{config}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Render(templates, cfg); err == nil || err.Error() != `"UNKNOWN" is not referenced by any of the templates` {
+		t.Fatalf("expected UNKNOWN (and only UNKNOWN) to be reported, got %v", err)
+	}
+	write("app/"+PackageManifest, "templates: [../shared.yml]\n")
+	write("shared.yml", "kind: Service\n")
+	if _, _, err := OpenPackage([]string{filepath.Join(dir, "app")}, "",
+		Options{Chroot: filepath.Join(dir, "app")}); err == nil || !strings.Contains(err.Error(), "outside of --chroot") {
+		t.Fatalf("expected template outside of --chroot to be rejected, got %v", err)
+	}
+	if _, _, err := OpenPackage([]string{filepath.Join(dir, "app")}, "", Options{Chroot: dir}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExpandTemplateFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubetpl-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, content := range map[string]string{
+		"k8s/b.yml":                   "",
+		"k8s/a.yaml":                  "",
+		"k8s/README.md":               "",
+		"k8s/.hidden.yml":             "",
+		"k8s/.kubetplignore":          "# comment\n*.json\n/skip/\ntmp*\n!tmp-keep.yml\n",
+		"k8s/tmp.yml":                 "",
+		"k8s/tmp-keep.yml":            "",
+		"k8s/skip/x.yml":              "",
+		"k8s/svc/c.json":              "",
+		"k8s/svc/z/d.yml":             "",
+		"k8s/svc/skip/e.yml":          "",
+		"k8s/svc/.kubetplignore":      "z/\n",
+		"k8s/web/kubetpl.yaml":        "",
+		"k8s/web/templates/f.yml":     "",
+		"k8s/web/templates/g.json":    "",
+		"k8s/web/templates/h.kubetpl": "",
+	} {
+		file := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	k8s := filepath.Join(dir, "k8s")
+	rel := func(files []string) string {
+		var r []string
+		for _, file := range files {
+			if p, err := filepath.Rel(k8s, file); err == nil {
+				file = filepath.ToSlash(p)
+			}
+			r = append(r, file)
+		}
+		return strings.Join(r, " ")
+	}
+	r := New(Options{})
+	for _, test := range []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{k8s}, "a.yaml b.yml svc/skip/e.yml tmp-keep.yml web/templates/f.yml web/templates/h.kubetpl"},
+		{[]string{filepath.Join(k8s, "**", "*.yml")}, "b.yml svc/skip/e.yml tmp-keep.yml web/templates/f.yml"},
+		{[]string{filepath.Join(k8s, "*.y*ml")}, "a.yaml b.yml tmp-keep.yml"},
+		{[]string{filepath.Join(k8s, "b.yml"), "-", k8s + "/./b.yml", "https://example.com/x.yml"},
+			"b.yml - https://example.com/x.yml"},
+		{[]string{filepath.Join(k8s, "tmp.yml")}, "tmp.yml"},
+	} {
+		actual, err := r.ExpandTemplateFiles(test.args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rel(actual) != test.expected {
+			t.Fatalf("%v: actual: \n%s != expected: \n%s", test.args, rel(actual), test.expected)
+		}
+	}
+	if _, err := r.ExpandTemplateFiles([]string{filepath.Join(k8s, "*.txt")}); err == nil {
+		t.Fatal("expected glob pattern that matches nothing to be reported")
+	}
+}
+
+func TestExpandTemplateFilesExcludesLibAndConfigFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubetpl-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, content := range map[string]string{
+		"k8s/deployment.yml":    "# kubetpl:syntax:$\nkind: Deployment\nmetadata:\n  name: $NAME\n",
+		"k8s/lib/labels.yml":    "app: $NAME\n",
+		"k8s/config/prod.yml":   "NAME: app\n",
+		"k8s/config/other.yaml": "kind: ConfigMap\n",
+	} {
+		file := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	k8s := filepath.Join(dir, "k8s")
+	r := New(Options{Lib: []string{filepath.Join(k8s, "lib")}})
+	if _, err := r.ReadConfigFiles(filepath.Join(k8s, "config", "prod.yml")); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{{k8s}, {filepath.Join(k8s, "**", "*.y*ml")}} {
+		actual, err := r.ExpandTemplateFiles(args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := []string{filepath.Join(k8s, "config", "other.yaml"), filepath.Join(k8s, "deployment.yml")}
+		if !reflect.DeepEqual(actual, expected) {
+			t.Fatalf("%v: actual: \n%v != expected: \n%v", args, actual, expected)
+		}
+	}
+}
+
+func TestMergeConfigCopiesSrc(t *testing.T) {
+	r := New(Options{ListMerge: ListMergeAppend})
+	src := map[string]interface{}{
+		"db":    map[interface{}]interface{}{"host": "a"},
+		"hosts": []interface{}{"a"},
+	}
+	first, second := make(map[string]interface{}), make(map[string]interface{})
+	for _, dst := range []map[string]interface{}{first, second} {
+		if err := r.MergeConfig(dst, src); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.MergeConfig(first, map[string]interface{}{
+		"db":    map[interface{}]interface{}{"port": 1},
+		"hosts": []interface{}{"b"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetValue(first, "hosts[0]", "c"); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		config   map[string]interface{}
+		expected string
+	}{
+		{src, `{"db":{"host":"a"},"hosts":["a"]}`},
+		{first, `{"db":{"host":"a","port":1},"hosts":["c","b"]}`},
+		{second, `{"db":{"host":"a"},"hosts":["a"]}`},
+	} {
+		actual, err := json.Marshal(yamlext.JSONCompatible(test.config))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(actual) != test.expected {
+			t.Fatalf("actual: \n%s != expected: \n%s", actual, test.expected)
+		}
+	}
+}
+
+func TestReadConfigFilesDeepMerge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubetpl-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	base, overlay := filepath.Join(dir, "base.yaml"), filepath.Join(dir, "prod.yaml")
+	ioutil.WriteFile(base, []byte("db:\n  host: a\n  port: 1\nports: [{name: http, port: 80}, {name: grpc}]\n"), 0600)
+	ioutil.WriteFile(overlay, []byte("db:\n  host: b\nports: [{name: http, port: 8080}, {name: debug}]\n"), 0600)
+	for _, test := range []struct {
+		listMerge string
+		expected  string
+	}{
+		{"", `{"db":{"host":"b","port":1},"ports":[{"name":"http","port":8080},{"name":"debug"}]}`},
+		{ListMergeAppend, `{"db":{"host":"b","port":1},"ports":[{"name":"http","port":80},{"name":"grpc"},` +
+			`{"name":"http","port":8080},{"name":"debug"}]}`},
+		{ListMergeByKey, `{"db":{"host":"b","port":1},"ports":[{"name":"http","port":8080},{"name":"grpc"},` +
+			`{"name":"debug"}]}`},
+	} {
+		config, err := New(Options{ListMerge: test.listMerge}).ReadConfigFiles(base, overlay)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.listMerge == "" {
+			if err := SetValue(config, "db.user.name", "admin"); err != nil {
+				t.Fatal(err)
+			}
+			if err := SetValue(config, "ports[2].port", 9090); err != nil {
+				t.Fatal(err)
+			}
+			if err := SetValue(config, "ports[5]", "x"); err == nil {
+				t.Fatal("expected out of range index to be reported")
+			}
+			test.expected = `{"db":{"host":"b","port":1,"user":{"name":"admin"}},` +
+				`"ports":[{"name":"http","port":8080},{"name":"debug"},{"port":9090}]}`
+		}
+		b, err := json.Marshal(yamlext.JSONCompatible(config))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != test.expected {
+			t.Fatalf("actual: \n%s != expected: \n%s", b, test.expected)
+		}
+	}
+	if _, err := New(Options{ListMerge: "unknown"}).ReadConfigFiles(base, overlay); err == nil {
+		t.Fatal("expected unknown list merge strategy to be reported")
+	}
+}
+
+func TestSetValueOverridesFlatKey(t *testing.T) {
+	files := map[string]string{
+		"template.$.yml":           "# kubetpl:syntax:$\nkind: ConfigMap\ndata:\n  host: ${db.host}\n",
+		"template.go-template.yml": "# kubetpl:syntax:go-template\nkind: ConfigMap\ndata:\n  host: {{ .db.host }}\n",
+		"config.yml":               "db.host: a\n",
+	}
+	r := New(Options{})
+	r.ReadFile = func(path string) ([]byte, error) {
+		content, ok := files[path]
+		if !ok {
+			return nil, fmt.Errorf("%s not found", path)
+		}
+		return []byte(content), nil
+	}
+	for _, template := range []string{"template.$.yml", "template.go-template.yml"} {
+		config, err := r.ReadConfigFiles("config.yml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := SetValue(config, "db.host", "b"); err != nil {
+			t.Fatal(err)
+		}
+		res, err := r.Render([]string{template}, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := res.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := "---\nkind: ConfigMap\ndata:\n  host: b\n"
+		if string(actual) != expected {
+			t.Fatalf("%s: actual: \n%s != expected: \n%s", template, actual, expected)
+		}
+	}
+}
+
+func TestReadConfigFilesReportsErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubetpl-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, expected := range map[string]string{
+		"malformed.yaml":  "malformed.yaml:2: ",
+		"duplicate.yaml":  `duplicate.yaml:4: duplicate key "port" (previously defined at line 3)`,
+		"non-string.yaml": `non-string.yaml:2: top-level key "true" is not a string`,
+		"list.yaml":       "list.yaml:1: expected a map",
+		"malformed.json":  "malformed.json:2: invalid character '}'",
+		"empty.yaml":      "",
+		"empty.json":      "",
+	} {
+		content := map[string]string{
+			"malformed.yaml":  "key: value\nlist: [a\n",
+			"duplicate.yaml":  "db:\n  host: a\n  port: 1\n  port: 2\n",
+			"non-string.yaml": "key: value\ntrue: value\n",
+			"list.yaml":       "- a\n- b\n",
+			"malformed.json":  "{\"key\": 1,\n}",
+		}[name]
+		file := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		_, err := New(Options{}).ReadConfigFiles(file)
+		if expected == "" {
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.HasPrefix(err.Error(), filepath.Join(dir, expected)) {
+			t.Fatalf("%s: expected error starting with %q, got %v", name, expected, err)
+		}
+	}
+}
+
+func TestFailOnUnknownKeys(t *testing.T) {
+	config := map[string]interface{}{"NAME": "app", "NAMESPACE": "default", "TYPO": "x"}
+	_, err := render([]string{"../example/nginx.$.yml"}, config, Options{FailOnUnknownKeys: true})
+	expected := "\"NAMESPACE\" is not referenced by any of the templates\n" +
+		"\"TYPO\" is not referenced by any of the templates"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("actual: \n%v != expected: \n%s", err, expected)
+	}
+	delete(config, "NAMESPACE")
+	delete(config, "TYPO")
+	config["MESSAGE"] = "hello"
+	if _, err := render([]string{"../example/nginx.$.yml"}, config, Options{FailOnUnknownKeys: true}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadEnv(t *testing.T) {
+	environ := []string{"APP_DB_HOST=db", "APP_TOKEN=a=b", "CI_TOKEN=ci", "HOME=/root", "APP_=x", "PASSWORD=secret"}
+	for _, test := range []struct {
+		opts     EnvOptions
+		expected map[string]interface{}
+	}{
+		{EnvOptions{}, map[string]interface{}{}},
+		{EnvOptions{Prefixes: []string{"APP_"}},
+			map[string]interface{}{"DB_HOST": "db", "TOKEN": "a=b"}},
+		{EnvOptions{Prefixes: []string{"APP_", "CI_"}, Names: []string{"PASSWORD", "MISSING"}},
+			map[string]interface{}{"DB_HOST": "db", "TOKEN": "ci", "PASSWORD": "secret"}},
+		{EnvOptions{Prefixes: []string{"APP_"}, KeepPrefix: true},
+			map[string]interface{}{"APP_DB_HOST": "db", "APP_TOKEN": "a=b", "APP_": "x"}},
+	} {
+		actual := ReadEnv(environ, test.opts)
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Fatalf("actual: \n%v != expected: \n%v", actual, test.expected)
+		}
+	}
+}
+
+func TestFreezeCustomPaths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubetpl-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tmpl := filepath.Join(dir, "template.yml")
+	ioutil.WriteFile(tmpl, []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app
+data:
+  key: value
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: app
+data:
+  key: dmFsdWU=
+---
+apiVersion: argoproj.io/v1alpha1
+kind: Rollout
+metadata:
+  name: app
+spec:
+  template:
+    spec:
+      containers:
+      - name: app
+        envFrom:
+        - configMapRef:
+            name: app
+        - secretRef:
+            name: app
+---
+apiVersion: keda.sh/v1alpha1
+kind: ScaledObject
+metadata:
+  name: app
+spec:
+  triggers:
+  - authenticationRef:
+      name: app
+`), 0600)
+	pathsFile := filepath.Join(dir, "freeze-paths.yml")
+	ioutil.WriteFile(pathsFile, []byte("Secret:\n  Rollout:\n  - spec.template.spec.containers[*].envFrom[*].secretRef.name\n"), 0600)
+	actual, err := render([]string{tmpl}, nil, Options{
+		Freeze: true,
+		FreezePaths: []string{
+			"Rollout:spec.template.spec.containers[*].envFrom[*].configMapRef.name",
+			"ScaledObject:spec.triggers[*].authenticationRef.name:Secret",
+		},
+		FreezePathsFiles: []string{pathsFile},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"configMapRef:\n            name: app-ac7478b\n",
+		"secretRef:\n            name: app-2c9b4df\n",
+		"authenticationRef:\n      name: app-2c9b4df\n",
+	} {
+		if !strings.Contains(string(actual), expected) {
+			t.Fatalf("%s does not contain %s", actual, expected)
+		}
+	}
+	for _, path := range []string{
+		"Rollout:spec.template.spec.containers[*].envFrom[*].ref.name",
+		"Rollout:spec.template.spec.containers[*]:ConfigMap",
+		"Rollout:spec..name:ConfigMap",
+		"Rollout:spec.name:Pod",
+		"ConfigMap:spec.name:ConfigMap",
+	} {
+		if _, err := render([]string{tmpl}, nil, Options{Freeze: true, FreezePaths: []string{path}}); err == nil {
+			t.Fatalf("expected %s to be rejected", path)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/shyiko/kubetpl/engine"
+	yamlext "github.com/shyiko/kubetpl/yaml"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+	"strings"
+)
+
+const (
+	DirectiveSyntax = "syntax"
+	DirectiveSet    = "set"
+)
+
+// Directive is a "# kubetpl:<key>:<value>" comment.
+type Directive struct {
+	Key, Value string
+}
+
+// ParseDirectives extracts "# kubetpl:<key>:<value>" directives from the template.
+func ParseDirectives(s []byte) ([]Directive, error) {
+	var d []Directive
+	for _, line := range strings.Split(string(s), "\n") {
+		if strings.HasPrefix(line, "# kubetpl:") {
+			split := append(strings.SplitN(line[strings.Index(line, ":")+1:], ":", 2), "")
+			key, value := split[0], split[1]
+			if key != DirectiveSyntax && key != DirectiveSet && key != DirectiveInclude {
+				return nil, fmt.Errorf("unrecognized # kubetpl:%s directive", key)
+			}
+			d = append(d, Directive{key, value})
+		}
+	}
+	return d, nil
+}
+
+// newTemplate returns template (along with its flavor, directives and lineMap (see expandIncludes)).
+func (r *Renderer) newTemplate(file string) (engine.Template, string, []Directive, lineMap, error) {
+	content, err := r.readFile(file)
+	if err != nil {
+		return nil, "", nil, nil, err
+	}
+	content = bytes.Replace(content, []byte("\r\n"), []byte("\n"), -1)
+	roots, err := r.includeRoots(file)
+	if err != nil {
+		return nil, "", nil, nil, err
+	}
+	content, lines, err := r.expandIncludes(file, content, roots, []string{file})
+	if err != nil {
+		return nil, "", nil, nil, err
+	}
+	directives, err := ParseDirectives(content)
+	if err != nil {
+		return nil, "", nil, nil, fmt.Errorf("%s: %s", file, err.Error())
+	}
+	flavor := r.Syntax
+	for _, d := range directives {
+		if d.Key == DirectiveSyntax {
+			flavor = d.Value
+		}
+	}
+	if flavor == "" {
+		if hasExtensionAny(file, ".yml.kubetpl", ".yaml.kubetpl", ".json.kubetpl-go") {
+			log.Warnf("*.{yml,yaml,json}.kubetpl as an indicator for \"$\" flavor has been deprecated" +
+				" (please use `--syntax=$` or `# kubetpl:syntax:$` directive instead)")
+			flavor = "$"
+		}
+		if hasExtensionAny(file, ".yml.kubetpl-go", ".yaml.kubetpl-go", ".json.kubetpl-go") {
+			log.Warnf("*.{yml,yaml,json}.kubetpl-go as an indicator for \"go-template\" flavor has been deprecated" +
+				" (please use `--syntax=go-template` or `# kubetpl:syntax:go-template` directive instead)")
+			flavor = "go-template"
+		}
+	}
+	var t engine.Template
+	switch flavor {
+	case "$":
+		var opts []engine.ShellTemplateOption
+		if r.IgnoreUnset {
+			opts = append(opts, engine.ShellTemplateIgnoreUnset())
+		}
+		if r.NodeAware {
+			opts = append(opts, engine.ShellTemplateNodeAware())
+		}
+		t, err = engine.NewShellTemplate(content, opts...)
+	case "go-template":
+		var lib map[string][]byte
+		if lib, err = r.readLib(); err != nil {
+			return nil, "", nil, nil, err
+		}
+		t, err = engine.NewGoTemplate(content, file, engine.GoTemplateLib(lib))
+	case "template-kind":
+		t, err = engine.NewTemplateKindTemplate(content, engine.TemplateKindTemplateDropNull(),
+			engine.TemplateKindTemplateKeepOrder())
+	default:
+		if flavor != "" {
+			return nil, "", nil, nil, fmt.Errorf("%s: unknown template type \"%s\" "+
+				"(expected \"$\", \"go-template\" or \"template-kind\")", file, flavor)
+		}
+		// warn if "kind: Template" is present
+		for _, chunk := range yamlext.Chunk(content) {
+			m := make(map[interface{}]interface{})
+			if err = yaml.Unmarshal(chunk, &m); err != nil {
+				return nil, "", nil, nil, fmt.Errorf("%s does not appear to be a valid YAML (%s).\n"+
+					"Did you forget to specify `--syntax=<$|go-template|template-kind>`"+
+					" / add \"# kubetpl:syntax:<$|go-template|template-kind>\"?", file, err.Error())
+			}
+			if m["kind"] == "Template" {
+				log.Warnf("%s is missing \"# kubetpl:syntax:template-kind\""+
+					" (use `--syntax=template-kind` if you can't add \"# kubetpl:syntax:template-kind\" to the template)", file)
+				break
+			}
+		}
+		t, err = engine.NewTemplateKindTemplate(content, engine.TemplateKindTemplateDropNull(),
+			engine.TemplateKindTemplateKeepOrder()) // change to simple pass-through in 1.0.0
+	}
+	if err != nil {
+		return nil, "", nil, nil, lines.remapError(file, err)
+	}
+	return t, flavor, directives, lines, nil
+}
@@ -0,0 +1,65 @@
+package render
+
+import (
+	"fmt"
+	"github.com/shyiko/kubetpl/engine"
+	"strings"
+)
+
+// TemplateVars is a list of variables referenced by a template.
+type TemplateVars struct {
+	Source string       `json:"template"`
+	Syntax string       `json:"syntax,omitempty"`
+	Vars   []engine.Var `json:"vars"`
+}
+
+// Vars lists variables referenced by each of the templateFiles
+// (taking "# kubetpl:set:KEY=VALUE" defaults into account).
+func (r *Renderer) Vars(templateFiles []string) ([]TemplateVars, error) {
+	templateFiles, err := r.ExpandTemplateFiles(templateFiles)
+	if err != nil {
+		return nil, err
+	}
+	var res []TemplateVars
+	for _, templateFile := range templateFiles {
+		t, flavor, directives, lines, err := r.newTemplate(templateFile)
+		if err != nil {
+			return nil, err
+		}
+		lister, ok := t.(engine.VarLister)
+		if !ok {
+			return nil, fmt.Errorf("%s: listing variables is not supported by \"%s\" templates", templateFile, flavor)
+		}
+		vars, err := lister.Vars()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", templateFile, err.Error())
+		}
+		for i := range vars {
+			for j := range vars[i].Usages {
+				u := &vars[i].Usages[j]
+				var source string
+				source, u.Line, u.Column = lines.resolve(u.Line, u.Column)
+				if source != templateFile {
+					u.File = source
+				}
+			}
+		}
+		for _, d := range directives {
+			if d.Key != DirectiveSet {
+				continue
+			}
+			split := strings.SplitN(d.Value, "=", 2)
+			if len(split) != 2 {
+				continue
+			}
+			for i := range vars {
+				if vars[i].Name == split[0] {
+					vars[i].Default = split[1]
+					vars[i].Required = false
+				}
+			}
+		}
+		res = append(res, TemplateVars{Source: templateFile, Syntax: flavor, Vars: vars})
+	}
+	return res, nil
+}
@@ -0,0 +1,125 @@
+package render
+
+import (
+	"os"
+	"sort"
+	"time"
+)
+
+// Watcher polls files for changes (modification time, size, creation/removal).
+// Polling (as opposed to inotify & co) is used to handle editors that replace files on save, network mounts, etc.
+type Watcher struct {
+	// How often files are checked (200ms if not set).
+	Interval time.Duration
+	// How long files need to stay unchanged for Wait to return (e.g. to let editor finish writing/renaming files).
+	Debounce time.Duration
+	state    map[string]fileState
+	prev     map[string]fileState
+	// (overridden in tests)
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+type fileState struct {
+	exists  bool
+	modTime time.Time
+	size    int64
+}
+
+func statFile(path string) fileState {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fileState{}
+	}
+	return fileState{true, fi.ModTime(), fi.Size()}
+}
+
+// Watch (re)sets the list of files to watch (state of the files at the moment of the call is used as a baseline).
+// Stdin ("-") and http(s):// URLs are ignored.
+func (w *Watcher) Watch(paths []string) {
+	w.Reset()
+	for _, path := range paths {
+		w.Add(path)
+	}
+}
+
+// Reset clears the list of files to watch (see Add).
+func (w *Watcher) Reset() {
+	w.prev, w.state = w.state, make(map[string]fileState)
+}
+
+// Add adds file to the list of files to watch. State of the file at the moment of the first call (since Reset)
+// is used as a baseline, which is why Add should be called before the file is read (that way changes made while
+// file is being rendered are not lost). Stdin ("-") and http(s):// URLs are ignored.
+func (w *Watcher) Add(path string) {
+	if path == "-" || isURL(path) {
+		return
+	}
+	if w.state == nil {
+		w.state = make(map[string]fileState)
+	}
+	if _, ok := w.state[path]; !ok {
+		w.state[path] = statFile(path)
+	}
+}
+
+// Keep carries files (along with their baselines) over from the list that was in effect before Reset
+// (e.g. when rendering failed before all the files were read). Files that weren't watched before are Add-ed.
+func (w *Watcher) Keep(paths []string) {
+	for _, path := range paths {
+		if state, ok := w.prev[path]; ok {
+			if _, ok := w.state[path]; !ok {
+				w.state[path] = state
+			}
+			continue
+		}
+		w.Add(path)
+	}
+}
+
+// Files returns (sorted) list of files being watched.
+func (w *Watcher) Files() []string {
+	var r []string
+	for path := range w.state {
+		r = append(r, path)
+	}
+	sort.Strings(r)
+	return r
+}
+
+// Wait blocks until any of the files changes (and then stays unchanged for Debounce).
+// Returns (sorted) list of files that changed.
+func (w *Watcher) Wait() []string {
+	interval := w.Interval
+	if interval <= 0 {
+		interval = 200 * time.Millisecond
+	}
+	now, sleep := w.now, w.sleep
+	if now == nil {
+		now = time.Now
+	}
+	if sleep == nil {
+		sleep = time.Sleep
+	}
+	changed := make(map[string]bool)
+	var lastChange time.Time
+	for {
+		sleep(interval)
+		for path, prev := range w.state {
+			if cur := statFile(path); cur != prev {
+				w.state[path] = cur
+				changed[path] = true
+				lastChange = now()
+			}
+		}
+		if len(changed) != 0 && now().Sub(lastChange) >= w.Debounce {
+			break
+		}
+	}
+	var r []string
+	for path := range changed {
+		r = append(r, path)
+	}
+	sort.Strings(r)
+	return r
+}
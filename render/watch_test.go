@@ -0,0 +1,91 @@
+package render
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeClock advances time on every sleep (running actions scheduled for the moment).
+type fakeClock struct {
+	t       time.Time
+	actions map[time.Duration]func()
+	start   time.Time
+}
+
+func newFakeClock(actions map[time.Duration]func()) *fakeClock {
+	t := time.Unix(0, 0)
+	return &fakeClock{t: t, start: t, actions: actions}
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func (c *fakeClock) sleep(d time.Duration) {
+	c.t = c.t.Add(d)
+	if action, ok := c.actions[c.t.Sub(c.start)]; ok {
+		action()
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubetpl-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	a, b := filepath.Join(dir, "a.yml"), filepath.Join(dir, "b.env")
+	if err := ioutil.WriteFile(a, []byte("a"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	clock := newFakeClock(map[time.Duration]func(){
+		30 * time.Millisecond: func() { ioutil.WriteFile(a, []byte("ab"), 0600) },
+		50 * time.Millisecond: func() { ioutil.WriteFile(b, []byte("b"), 0600) }, // created
+	})
+	w := &Watcher{Interval: 10 * time.Millisecond, Debounce: 50 * time.Millisecond, now: clock.now, sleep: clock.sleep}
+	w.Watch([]string{a, b, "-", "https://example.com/template.yml"})
+	if !reflect.DeepEqual(w.Files(), []string{a, b}) {
+		t.Fatal(w.Files())
+	}
+	if changed := w.Wait(); !reflect.DeepEqual(changed, []string{a, b}) {
+		t.Fatal(changed)
+	}
+	if elapsed := clock.t.Sub(clock.start); elapsed != 100*time.Millisecond {
+		t.Fatalf("expected Wait to return 50ms (debounce) after the last change, instead it took %s", elapsed)
+	}
+}
+
+func TestWatcherUsesStateBeforeRead(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubetpl-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	a, b := filepath.Join(dir, "a.yml"), filepath.Join(dir, "b.yml")
+	for _, file := range []string{a, b} {
+		if err := ioutil.WriteFile(file, []byte("a"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	clock := newFakeClock(nil)
+	w := &Watcher{Interval: 10 * time.Millisecond, now: clock.now, sleep: clock.sleep}
+	w.Watch([]string{b})
+	w.Reset()
+	w.Add(a)
+	// file saved while being rendered
+	if err := ioutil.WriteFile(a, []byte("ab"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	w.Add(a) // (baseline must not be updated)
+	w.Keep([]string{b})
+	if err := ioutil.WriteFile(b, []byte("ab"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if changed := w.Wait(); !reflect.DeepEqual(changed, []string{a, b}) {
+		t.Fatal(changed)
+	}
+}
@@ -0,0 +1,557 @@
+package yaml
+
+import (
+	"bytes"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	yamlv3 "gopkg.in/yaml.v3"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ParseNode parses YAML document into a node tree (which, unlike map[interface{}]interface{}, preserves key order
+// and comments). Plain scalars are tagged the way gopkg.in/yaml.v2 (and, by extension, kubectl) would interpret
+// them (e.g. "yes" is a !!bool and not a !!str as per YAML 1.2).
+func ParseNode(in []byte) (*yamlv3.Node, error) {
+	var doc yamlv3.Node
+	if err := yamlv3.Unmarshal(in, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Kind == 0 {
+		doc.Kind = yamlv3.DocumentNode
+	}
+	walkNodes(&doc, func(n *yamlv3.Node) {
+		if n.Kind == yamlv3.ScalarNode && n.Style == 0 && n.Tag != "!!merge" {
+			n.Tag = plainTag(n.Value)
+		}
+	})
+	return &doc, nil
+}
+
+// CheckDuplicateKeys returns an error (mentioning line of the duplicate) if any of the mappings within n contains
+// the same key more than once.
+func CheckDuplicateKeys(n *yamlv3.Node) error {
+	var err error
+	walkNodes(n, func(n *yamlv3.Node) {
+		if err != nil || n.Kind != yamlv3.MappingNode {
+			return
+		}
+		seen := make(map[string]int)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key := n.Content[i]
+			if key.Kind != yamlv3.ScalarNode || key.Tag == "!!merge" {
+				continue
+			}
+			id := key.Tag + " " + key.Value
+			if line, ok := seen[id]; ok {
+				err = fmt.Errorf("line %d: duplicate key \"%s\" (previously defined at line %d)", key.Line, key.Value, line)
+				return
+			}
+			seen[id] = key.Line
+		}
+	})
+	return err
+}
+
+func plainTag(value string) string {
+	var v interface{}
+	if err := yaml.Unmarshal([]byte(value), &v); err != nil {
+		return "!!str"
+	}
+	switch v.(type) {
+	case nil:
+		return "!!null"
+	case bool:
+		return "!!bool"
+	case int, int64, uint64:
+		return "!!int"
+	case float64:
+		return "!!float"
+	default:
+		return "!!str"
+	}
+}
+
+func walkNodes(n *yamlv3.Node, cb func(n *yamlv3.Node)) {
+	cb(n)
+	for _, c := range n.Content {
+		walkNodes(c, cb)
+	}
+}
+
+// DecodeNode converts node into a value of the same shape gopkg.in/yaml.v2 would produce
+// (map[interface{}]interface{}, []interface{}, string, int, etc).
+func DecodeNode(n *yamlv3.Node) interface{} {
+	switch n.Kind {
+	case yamlv3.DocumentNode:
+		if len(n.Content) == 0 {
+			return nil
+		}
+		return DecodeNode(n.Content[0])
+	case yamlv3.MappingNode:
+		m := make(map[interface{}]interface{}, len(n.Content)/2)
+		var merged []interface{}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if n.Content[i].Tag == "!!merge" {
+				merged = append(merged, DecodeNode(n.Content[i+1]))
+				continue
+			}
+			if key := DecodeNode(n.Content[i]); isHashable(key) {
+				m[key] = DecodeNode(n.Content[i+1])
+			}
+		}
+		// "<<: *x" / "<<: [*x, *y]" (keys that are set explicitly (or by the preceding merge) take precedence)
+		for _, v := range merged {
+			sources, ok := v.([]interface{})
+			if !ok {
+				sources = []interface{}{v}
+			}
+			for _, source := range sources {
+				if sm, ok := source.(map[interface{}]interface{}); ok {
+					for k, v := range sm {
+						if _, ok := m[k]; !ok {
+							m[k] = v
+						}
+					}
+				}
+			}
+		}
+		return m
+	case yamlv3.SequenceNode:
+		s := make([]interface{}, 0, len(n.Content))
+		for _, c := range n.Content {
+			s = append(s, DecodeNode(c))
+		}
+		return s
+	case yamlv3.AliasNode:
+		return DecodeNode(n.Alias)
+	case yamlv3.ScalarNode:
+		if n.Style&yamlv3.TaggedStyle != 0 {
+			var v interface{}
+			if out, err := yamlv3.Marshal(n); err == nil && yaml.Unmarshal(out, &v) == nil {
+				return v
+			}
+			return n.Value
+		}
+		if n.Style == 0 {
+			var v interface{}
+			if err := yaml.Unmarshal([]byte(n.Value), &v); err == nil {
+				return v
+			}
+		}
+		return n.Value
+	}
+	return nil
+}
+
+// SyncNode updates node tree to match v (as produced by gopkg.in/yaml.v2), keeping order, comments and style
+// of everything that is left unchanged (aliases and "<<" merge keys included). New map keys are appended
+// (in sorted order), sequences are synced item by item.
+func SyncNode(n *yamlv3.Node, v interface{}) {
+	if n.Kind == yamlv3.DocumentNode {
+		if len(n.Content) == 0 {
+			n.Content = []*yamlv3.Node{encodeNode(v)}
+			return
+		}
+		n = n.Content[0]
+	}
+	if reflect.DeepEqual(DecodeNode(n), v) {
+		return
+	}
+	switch n.Kind {
+	case yamlv3.MappingNode:
+		m, ok := v.(map[interface{}]interface{})
+		if !ok {
+			break
+		}
+		seen := make(map[interface{}]bool, len(m))
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if key := DecodeNode(n.Content[i]); n.Content[i].Tag != "!!merge" && isHashable(key) {
+				if _, ok := m[key]; ok {
+					seen[key] = true
+				}
+			}
+		}
+		content := n.Content[:0:0]
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if n.Content[i].Tag == "!!merge" {
+				// kept only if all the keys it provides are unchanged (the rest are added explicitly below)
+				merged := DecodeNode(&yamlv3.Node{Kind: yamlv3.MappingNode, Content: n.Content[i : i+2]}).(map[interface{}]interface{})
+				unchanged := true
+				for key, value := range merged {
+					if mv, ok := m[key]; !ok || (!seen[key] && !reflect.DeepEqual(mv, value)) {
+						unchanged = false
+						break
+					}
+				}
+				if unchanged {
+					for key := range merged {
+						seen[key] = true
+					}
+					content = append(content, n.Content[i], n.Content[i+1])
+				}
+				continue
+			}
+			key := DecodeNode(n.Content[i])
+			if !isHashable(key) {
+				continue // (not representable in v)
+			}
+			value, ok := m[key]
+			if !ok {
+				continue // removed
+			}
+			SyncNode(n.Content[i+1], value)
+			content = append(content, n.Content[i], n.Content[i+1])
+		}
+		var added []interface{}
+		for key := range m {
+			if !seen[key] {
+				added = append(added, key)
+			}
+		}
+		sort.Slice(added, func(i, j int) bool {
+			return fmt.Sprintf("%v", added[i]) < fmt.Sprintf("%v", added[j])
+		})
+		for _, key := range added {
+			content = append(content, encodeNode(key), encodeNode(m[key]))
+		}
+		n.Content = content
+		return
+	case yamlv3.SequenceNode:
+		s, ok := v.([]interface{})
+		if !ok {
+			break
+		}
+		content := n.Content
+		if len(content) > len(s) {
+			content = content[:len(s)]
+		}
+		for i, c := range content {
+			SyncNode(c, s[i])
+		}
+		for _, item := range s[len(content):] {
+			content = append(content, encodeNode(item))
+		}
+		n.Content = content
+		return
+	}
+	replacement := encodeNode(v)
+	if _, isString := v.(string); isString && n.Kind == yamlv3.ScalarNode &&
+		n.Style&(yamlv3.DoubleQuotedStyle|yamlv3.SingleQuotedStyle) != 0 {
+		replacement.Style = n.Style
+	}
+	replacement.HeadComment, replacement.LineComment, replacement.FootComment =
+		n.HeadComment, n.LineComment, n.FootComment
+	*n = *replacement
+}
+
+func isHashable(v interface{}) bool {
+	switch v.(type) {
+	case map[interface{}]interface{}, []interface{}:
+		return false
+	}
+	return true
+}
+
+func encodeNode(v interface{}) *yamlv3.Node {
+	var n yamlv3.Node
+	if err := n.Encode(v); err != nil {
+		return &yamlv3.Node{Kind: yamlv3.ScalarNode, Tag: "!!str", Value: fmt.Sprintf("%v", v)}
+	}
+	return &n
+}
+
+// MarshalNode serializes node tree produced by ParseNode (comments included).
+// Unlike gopkg.in/yaml.v3, indentation of block sequences (e.g. "key:\n- item" vs "key:\n  - item") and nested
+// mappings is kept as is.
+func MarshalNode(n *yamlv3.Node) ([]byte, error) {
+	p := &printer{}
+	if n.Kind == yamlv3.DocumentNode {
+		p.comment(n.HeadComment, 0)
+		if len(n.Content) != 0 {
+			p.block(n.Content[0], 0, nil)
+		}
+		p.comment(n.FootComment, 0)
+	} else {
+		p.block(n, 0, nil)
+	}
+	return p.buf.Bytes(), p.err
+}
+
+type printer struct {
+	buf bytes.Buffer
+	err error
+}
+
+func (p *printer) write(s ...string) {
+	for _, v := range s {
+		p.buf.WriteString(v)
+	}
+}
+
+func (p *printer) indent(indent int) {
+	p.buf.WriteString(strings.Repeat(" ", indent))
+}
+
+func (p *printer) comment(text string, indent int) {
+	if text == "" {
+		return
+	}
+	for _, line := range strings.Split(text, "\n") {
+		if line != "" {
+			p.indent(indent)
+			p.write(line)
+		}
+		p.write("\n")
+	}
+}
+
+func (p *printer) lineComment(comments ...string) {
+	for _, c := range comments {
+		if c != "" {
+			p.write(" ", c)
+		}
+	}
+}
+
+func isBlockCollection(n *yamlv3.Node) bool {
+	return (n.Kind == yamlv3.MappingNode || n.Kind == yamlv3.SequenceNode) &&
+		n.Style&yamlv3.FlowStyle == 0 && len(n.Content) != 0
+}
+
+// block prints node at a given indentation level
+// (parent is the key (if node is a value of a mapping) used to preserve original indentation).
+func (p *printer) block(n *yamlv3.Node, indent int, parent *yamlv3.Node) {
+	switch {
+	case n.Kind == yamlv3.MappingNode && isBlockCollection(n):
+		p.comment(n.HeadComment, indent)
+		p.mapping(n, indent, false)
+		p.comment(n.FootComment, indent)
+	case n.Kind == yamlv3.SequenceNode && isBlockCollection(n):
+		p.comment(n.HeadComment, indent)
+		p.sequence(n, indent, false)
+		p.comment(n.FootComment, indent)
+	default:
+		p.comment(n.HeadComment, indent)
+		p.indent(indent)
+		p.value(n, indent)
+		p.comment(n.FootComment, indent)
+	}
+}
+
+// childIndent returns indentation of the mapping/sequence n (value of the key).
+func childIndent(n *yamlv3.Node, key *yamlv3.Node, indent int) int {
+	if n.Line != 0 && key.Line != 0 && n.Line != key.Line {
+		if d := n.Column - key.Column; d >= 0 && (d > 0 || n.Kind == yamlv3.SequenceNode) {
+			return indent + d
+		}
+	}
+	if n.Kind == yamlv3.SequenceNode {
+		return indent
+	}
+	return indent + 2
+}
+
+func (p *printer) mapping(n *yamlv3.Node, indent int, inline bool) {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		k, v := n.Content[i], n.Content[i+1]
+		if !inline || i != 0 {
+			p.comment(k.HeadComment, indent)
+			p.indent(indent)
+		}
+		p.write(p.scalar(k), ":")
+		if isBlockCollection(v) {
+			p.properties(v, " ")
+			p.lineComment(k.LineComment, v.LineComment)
+			p.write("\n")
+			p.comment(v.HeadComment, indent)
+			ci := childIndent(v, k, indent)
+			if v.Kind == yamlv3.MappingNode {
+				p.mapping(v, ci, false)
+			} else {
+				p.sequence(v, ci, false)
+			}
+		} else {
+			p.write(" ")
+			p.lineComment(k.LineComment)
+			p.value(v, indent)
+		}
+		p.comment(k.FootComment, indent)
+		p.comment(v.FootComment, indent)
+	}
+}
+
+func (p *printer) sequence(n *yamlv3.Node, indent int, inline bool) {
+	for i, item := range n.Content {
+		if item.Kind == yamlv3.MappingNode && isBlockCollection(item) && item.Anchor == "" &&
+			item.Style&yamlv3.TaggedStyle == 0 {
+			// "- key: value" (comments preceding the first key are moved above "-")
+			p.comment(item.HeadComment, indent)
+			p.comment(item.Content[0].HeadComment, indent)
+			if !inline || i != 0 {
+				p.indent(indent)
+			}
+			p.write("- ")
+			first := *item.Content[0]
+			first.HeadComment = ""
+			m := *item
+			m.Content = append([]*yamlv3.Node{&first}, item.Content[1:]...)
+			p.mapping(&m, indent+2, true)
+			p.comment(item.FootComment, indent)
+			continue
+		}
+		p.comment(item.HeadComment, indent)
+		if !inline || i != 0 {
+			p.indent(indent)
+		}
+		p.write("-")
+		if isBlockCollection(item) {
+			if item.Anchor != "" || item.Style&yamlv3.TaggedStyle != 0 {
+				p.properties(item, " ")
+				p.lineComment(item.LineComment)
+				p.write("\n")
+				if item.Kind == yamlv3.MappingNode {
+					p.mapping(item, indent+2, false)
+				} else {
+					p.sequence(item, indent+2, false)
+				}
+			} else {
+				p.write(" ")
+				p.sequence(item, indent+2, true)
+			}
+		} else {
+			p.write(" ")
+			p.value(item, indent)
+		}
+		p.comment(item.FootComment, indent)
+	}
+}
+
+// properties writes anchor/tag of the collection.
+func (p *printer) properties(n *yamlv3.Node, prefix string) {
+	if n.Anchor != "" {
+		p.write(prefix, "&", n.Anchor)
+	}
+	if n.Style&yamlv3.TaggedStyle != 0 && n.Tag != "" {
+		p.write(prefix, n.Tag)
+	}
+}
+
+// value prints a non-block-collection node (followed by a line comment (if any) and a line break).
+func (p *printer) value(n *yamlv3.Node, indent int) {
+	if isBlockScalar(n) {
+		value := n.Value
+		header := "|"
+		if n.Style&yamlv3.FoldedStyle != 0 && !strings.Contains(strings.TrimRight(value, "\n"), "\n") {
+			header = ">"
+		}
+		switch {
+		case !strings.HasSuffix(value, "\n"):
+			header += "-"
+		case strings.HasSuffix(value, "\n\n"):
+			header += "+"
+			value = value[:len(value)-1]
+		default:
+			value = value[:len(value)-1]
+		}
+		if n.Anchor != "" {
+			p.write("&", n.Anchor, " ")
+		}
+		p.write(header)
+		p.lineComment(n.LineComment)
+		p.write("\n")
+		for _, line := range strings.Split(value, "\n") {
+			if line != "" {
+				p.indent(indent + 2)
+				p.write(line)
+			}
+			p.write("\n")
+		}
+		return
+	}
+	switch n.Kind {
+	case yamlv3.AliasNode:
+		p.write("*", n.Value)
+	case yamlv3.ScalarNode:
+		p.write(p.scalar(n))
+	default: // flow/empty collection
+		c := *n
+		c.HeadComment, c.LineComment, c.FootComment = "", "", ""
+		c.Style |= yamlv3.FlowStyle
+		p.write(p.encode(&c))
+	}
+	p.lineComment(n.LineComment)
+	p.write("\n")
+}
+
+func isBlockScalar(n *yamlv3.Node) bool {
+	if n.Kind != yamlv3.ScalarNode || n.Style&(yamlv3.TaggedStyle|yamlv3.DoubleQuotedStyle|yamlv3.SingleQuotedStyle) != 0 {
+		return false
+	}
+	if n.Style&(yamlv3.LiteralStyle|yamlv3.FoldedStyle) == 0 && !strings.Contains(n.Value, "\n") {
+		return false
+	}
+	// fallback to double-quoted style when value can't be represented as a block scalar without indentation indicator
+	// (or contains characters that aren't allowed in block scalars)
+	if strings.HasPrefix(n.Value, " ") || strings.HasPrefix(n.Value, "\n") || strings.Trim(n.Value, "\n") == "" {
+		return false
+	}
+	for _, r := range n.Value {
+		if r != '\n' && r != '\t' && (r < 0x20 || r == 0x7f || r == 0xfeff) {
+			return false
+		}
+	}
+	return true
+}
+
+// scalar returns a single-line representation of the scalar.
+func (p *printer) scalar(n *yamlv3.Node) string {
+	if n.Kind == yamlv3.AliasNode {
+		return "*" + n.Value
+	}
+	if n.Kind != yamlv3.ScalarNode {
+		c := *n
+		c.HeadComment, c.LineComment, c.FootComment = "", "", ""
+		c.Style |= yamlv3.FlowStyle
+		return p.encode(&c)
+	}
+	c := *n
+	c.HeadComment, c.LineComment, c.FootComment = "", "", ""
+	var anchor string
+	if c.Anchor != "" {
+		anchor, c.Anchor = "&"+c.Anchor+" ", ""
+	}
+	if c.Style&yamlv3.TaggedStyle == 0 {
+		if c.Style&(yamlv3.LiteralStyle|yamlv3.FoldedStyle) != 0 || strings.Contains(c.Value, "\n") {
+			c.Style = yamlv3.DoubleQuotedStyle
+		}
+		if c.Style == 0 && c.Tag != "" && c.Tag != "!!str" {
+			// as interpreted by yaml.v2 (see ParseNode)
+			return anchor + c.Value
+		}
+		if c.Style == 0 {
+			c.Tag = "!!str"
+			s := p.encode(&c)
+			// make sure value isn't going to be misinterpreted by yaml.v2 (e.g. "yes" -> true)
+			var v interface{}
+			if err := yaml.Unmarshal([]byte(s), &v); err != nil || v != c.Value {
+				c.Style = yamlv3.DoubleQuotedStyle
+				s = p.encode(&c)
+			}
+			return anchor + s
+		}
+	}
+	return anchor + p.encode(&c)
+}
+
+func (p *printer) encode(n *yamlv3.Node) string {
+	out, err := yamlv3.Marshal(n)
+	if err != nil {
+		if p.err == nil {
+			p.err = err
+		}
+		return ""
+	}
+	return strings.TrimSuffix(string(out), "\n")
+}
@@ -0,0 +1,82 @@
+package yaml
+
+import (
+	"testing"
+)
+
+func TestMarshalNodePreservesOrderAndComments(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		in   string
+	}{
+		{"order", "kind: Service\napiVersion: v1\nmetadata:\n  name: app\n"},
+		{"comments", "# head\nkind: ConfigMap # kind\nmetadata:\n  # name\n  name: app\ndata:\n  key: value\n  # foot\n# kubesec:v:3\n"},
+		{"sequences", "a:\n- 1\n- two\nb:\n  - c: 1\n    d: [1, 2]\n  - - x\n    - y\n"},
+		{"indentation", "a:\n    b:\n        c: 1\n"},
+		{"block scalars", "a: |\n  line 1\n  line 2\nb: >-\n  text\nc: |+\n  keep\n\n"},
+		{"quoted", "a: \"yes\"\nb: 'no'\nc: \"1\"\n"},
+		{"yaml 1.1 scalars", "a: yes\nb: on\nc: 0777\n"},
+		{"anchors", "a: &x\n  b: 1\nc: *x\n"},
+		{"empty collections", "a: {}\nb: []\n"},
+	} {
+		node, err := ParseNode([]byte(test.in))
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		actual, err := MarshalNode(node)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		if string(actual) != test.in {
+			t.Fatalf("%s: actual: \n%s != expected: \n%s", test.name, actual, test.in)
+		}
+	}
+}
+
+func TestSyncNode(t *testing.T) {
+	node, err := ParseNode([]byte("kind: ConfigMap\n# comment\nmetadata:\n  name: \"app\" # name\n  labels:\n    a: b\ndata:\n  k: v\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	SyncNode(node, map[interface{}]interface{}{
+		"kind": "ConfigMap",
+		"metadata": map[interface{}]interface{}{
+			"name": "app-1234567",
+		},
+		"data": map[interface{}]interface{}{
+			"k":    "v",
+			"z":    "yes",
+			"file": "line 1\nline 2\n",
+		},
+	})
+	actual, err := MarshalNode(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "kind: ConfigMap\n# comment\nmetadata:\n  name: \"app-1234567\" # name\ndata:\n  k: v\n  file: |\n    line 1\n    line 2\n  z: \"yes\"\n"
+	if string(actual) != expected {
+		t.Fatalf("actual: \n%s != expected: \n%s", actual, expected)
+	}
+}
+
+func TestSyncNodeKeepsStyleAndMergeKeys(t *testing.T) {
+	node, err := ParseNode([]byte("base: &base\n  a: 1\n  b: 2\nx:\n  <<: *base\n  c: [1, 2, 3]\n" +
+		"q:\n  <<: *base\n  c: 1\n? [k]\n: v\nz: 1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	SyncNode(node, map[interface{}]interface{}{
+		"base": map[interface{}]interface{}{"a": 1, "b": 2},
+		"x":    map[interface{}]interface{}{"a": 1, "b": 2, "c": []interface{}{1, 3}},
+		"q":    map[interface{}]interface{}{"a": 1, "b": 3, "c": 1},
+		"z":    2,
+	})
+	actual, err := MarshalNode(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "base: &base\n  a: 1\n  b: 2\nx:\n  <<: *base\n  c: [1, 3]\nq:\n  c: 1\n  a: 1\n  b: 3\nz: 2\n"
+	if string(actual) != expected {
+		t.Fatalf("actual: \n%s != expected: \n%s", actual, expected)
+	}
+}
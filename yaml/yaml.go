@@ -1,11 +1,154 @@
 package yaml
 
 import (
+	"bufio"
 	"bytes"
+	"fmt"
+	"io"
 )
 
+// Chunk splits YAML stream into documents (see Splitter).
 func Chunk(in []byte) [][]byte {
-	return bytes.Split(in, []byte("\n---\n"))
+	var r [][]byte
+	for _, doc := range Split(in) {
+		r = append(r, doc.Content)
+	}
+	return r
+}
+
+// Split splits YAML stream into documents (see Splitter).
+func Split(in []byte) []Document {
+	var r []Document
+	s := NewSplitter(bytes.NewReader(in))
+	if len(in) >= maxLineLength {
+		// (so that no line can exceed the buffer (bufio.ErrTooLong))
+		s.scanner.Buffer(make([]byte, 64*1024), len(in)+1)
+	}
+	for {
+		doc, err := s.Next()
+		if err != nil {
+			break // io.EOF (bytes.Reader never fails and no line can be longer than in)
+		}
+		r = append(r, doc)
+	}
+	return r
+}
+
+// Document is a single document of YAML stream.
+type Document struct {
+	Content []byte
+	// 0-based line number at which Content starts within the stream
+	Line int
+}
+
+// Splitter splits YAML stream into documents (one at a time).
+//
+// As per YAML spec, "---" (document start) and "..." (document end) markers are only recognized
+// at the beginning of the line and only when followed by a whitespace (or a line break), which is why (unlike
+// bytes.Split(in, "\n---\n")) "--- # comment", "---" on the first line, "..." and "---" inside block scalars
+// are all handled correctly. Content following "---" (e.g. "--- # comment", "--- |") is kept as a part of the
+// document. Comments preceding the first "---" (or following "...") are treated as a part of the next document
+// while "%" directives are dropped.
+type Splitter struct {
+	scanner *bufio.Scanner
+	line    int
+	// buffered (not yet returned) document
+	buf     [][]byte
+	bufLine int
+	// true if buf contains nothing but comments/blank lines and wasn't started with "---"
+	preamble bool
+	emitted  bool
+	eof      bool
+}
+
+// maximum length of the line Splitter can handle (Next returns bufio.ErrTooLong otherwise)
+var maxLineLength = 64 * 1024 * 1024
+
+func NewSplitter(r io.Reader) *Splitter {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineLength)
+	return &Splitter{scanner: scanner, preamble: true}
+}
+
+// Next returns next document or io.EOF if there are none left.
+func (s *Splitter) Next() (Document, error) {
+	for !s.eof {
+		if !s.scanner.Scan() {
+			if err := s.scanner.Err(); err != nil {
+				return Document{}, err
+			}
+			s.eof = true
+			if s.preamble && s.emitted {
+				break // nothing but comments after "..."
+			}
+			return s.flush(), nil
+		}
+		line := s.scanner.Bytes()
+		lineNo := s.line
+		s.line++
+		if len(line) > 0 && line[len(line)-1] == '\r' {
+			line = line[:len(line)-1]
+		}
+		switch {
+		case isMarker(line, "---"):
+			var doc Document
+			flush := !s.preamble
+			if flush {
+				doc = s.flush()
+			}
+			s.preamble = false
+			if rest := bytes.TrimLeft(line[3:], " \t"); len(rest) > 0 {
+				s.append(rest, lineNo)
+			} else if len(s.buf) == 0 {
+				s.bufLine = lineNo + 1
+			}
+			if flush {
+				return doc, nil
+			}
+		case isMarker(line, "..."):
+			if s.preamble {
+				continue
+			}
+			doc := s.flush()
+			s.preamble = true
+			return doc, nil
+		case s.preamble && bytes.HasPrefix(line, []byte("%")):
+			// directive (e.g. %YAML 1.2)
+		default:
+			if !isCommentOrBlank(line) {
+				s.preamble = false
+			}
+			s.append(line, lineNo)
+		}
+	}
+	return Document{}, io.EOF
+}
+
+func (s *Splitter) append(line []byte, lineNo int) {
+	if len(s.buf) == 0 {
+		s.bufLine = lineNo
+	}
+	s.buf = append(s.buf, append([]byte(nil), line...))
+}
+
+func (s *Splitter) flush() Document {
+	doc := Document{Content: bytes.Join(s.buf, []byte("\n")), Line: s.bufLine}
+	if doc.Content == nil {
+		doc.Content = []byte{}
+	}
+	s.buf, s.bufLine = nil, s.line
+	s.emitted = true
+	return doc
+}
+
+func isMarker(line []byte, marker string) bool {
+	return bytes.HasPrefix(line, []byte(marker)) &&
+		(len(line) == len(marker) || line[len(marker)] == ' ' || line[len(marker)] == '\t')
+}
+
+func isCommentOrBlank(line []byte) bool {
+	line = bytes.TrimSpace(line)
+	return len(line) == 0 || line[0] == '#'
 }
 
 func Header(in []byte) []byte {
@@ -48,3 +191,30 @@ func IsBasicType(v interface{}) bool {
 		return false
 	}
 }
+
+// JSONCompatible converts map[interface{}]interface{}s (as produced by yaml.Unmarshal) into map[string]interface{}s
+// so that value could be passed to json.Marshal.
+func JSONCompatible(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for key, value := range t {
+			m[fmt.Sprintf("%v", key)] = JSONCompatible(value)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for key, value := range t {
+			m[key] = JSONCompatible(value)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(t))
+		for i, value := range t {
+			s[i] = JSONCompatible(value)
+		}
+		return s
+	default:
+		return v
+	}
+}
@@ -0,0 +1,80 @@
+package yaml
+
+import (
+	"bufio"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		in       string
+		expected []Document
+	}{
+		{"empty", "", []Document{{[]byte(""), 0}}},
+		{"single", "a: 1\n", []Document{{[]byte("a: 1"), 0}}},
+		{"separator", "a: 1\n---\nb: 2\n", []Document{{[]byte("a: 1"), 0}, {[]byte("b: 2"), 2}}},
+		{"leading marker", "---\na: 1\n---\nb: 2", []Document{{[]byte("a: 1"), 1}, {[]byte("b: 2"), 3}}},
+		{"leading comments", "# kubetpl:syntax:$\n\n---\na: 1",
+			[]Document{{[]byte("# kubetpl:syntax:$\n\na: 1"), 0}}},
+		{"marker with trailing spaces", "a: 1\n---   \nb: 2", []Document{{[]byte("a: 1"), 0}, {[]byte("b: 2"), 2}}},
+		{"marker with comment", "a: 1\n--- # comment\nb: 2",
+			[]Document{{[]byte("a: 1"), 0}, {[]byte("# comment\nb: 2"), 1}}},
+		{"marker with content", "--- |\n  text\n--- !!map\na: 1",
+			[]Document{{[]byte("|\n  text"), 0}, {[]byte("!!map\na: 1"), 2}}},
+		{"not a marker", "a: ---\nb: ----\n----\n---a", []Document{{[]byte("a: ---\nb: ----\n----\n---a"), 0}}},
+		{"block scalar", "a: |\n  ---\n  b\n---\nc: 1", []Document{{[]byte("a: |\n  ---\n  b"), 0}, {[]byte("c: 1"), 4}}},
+		{"document end", "a: 1\n...\n---\nb: 2\n...\n", []Document{{[]byte("a: 1"), 0}, {[]byte("b: 2"), 3}}},
+		{"document end without start", "a: 1\n...\nb: 2\n...\n# trailing comment\n",
+			[]Document{{[]byte("a: 1"), 0}, {[]byte("b: 2"), 2}}},
+		{"comments after document end", "a: 1\n...\n# header\n---\nb: 2",
+			[]Document{{[]byte("a: 1"), 0}, {[]byte("# header\nb: 2"), 2}}},
+		{"directives", "%YAML 1.2\n---\na: 1\n...\n%YAML 1.2\n---\nb: 2",
+			[]Document{{[]byte("a: 1"), 2}, {[]byte("b: 2"), 6}}},
+		{"empty documents", "a: 1\n---\n---\nb: 2\n---\n",
+			[]Document{{[]byte("a: 1"), 0}, {[]byte(""), 2}, {[]byte("b: 2"), 3}, {[]byte(""), 5}}},
+		{"footer", "a: 1\n# kubesec:v:3\n---\nb: 2",
+			[]Document{{[]byte("a: 1\n# kubesec:v:3"), 0}, {[]byte("b: 2"), 3}}},
+		{"CRLF", "a: 1\r\n---\r\nb: 2\r\n", []Document{{[]byte("a: 1"), 0}, {[]byte("b: 2"), 2}}},
+	} {
+		actual := Split([]byte(test.in))
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%s: actual: \n%q != expected: \n%q", test.name, actual, test.expected)
+		}
+	}
+}
+
+func TestSplitLongLine(t *testing.T) {
+	defer func(v int) { maxLineLength = v }(maxLineLength)
+	maxLineLength = 16
+	in := "a: 1\n---\nb: " + strings.Repeat("x", 64*1024+1) + "\n---\nc: 3\n"
+	if _, err := NewSplitter(strings.NewReader(in)).Next(); err != nil {
+		t.Fatal(err)
+	}
+	s := NewSplitter(strings.NewReader(in))
+	s.Next()
+	if _, err := s.Next(); err != bufio.ErrTooLong {
+		t.Fatalf("expected bufio.ErrTooLong, instead got %v", err)
+	}
+	actual := Split([]byte(in))
+	expected := []Document{{[]byte("a: 1"), 0}, {[]byte("b: " + strings.Repeat("x", 64*1024+1)), 2},
+		{[]byte("c: 3"), 4}}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("actual: \n%d document(s) != expected: \n%d document(s)", len(actual), len(expected))
+	}
+}
+
+func TestHeaderFooter(t *testing.T) {
+	docs := Chunk([]byte("--- # header\nkind: Secret\n# kubesec:v:3\n# kubesec:mac:x\n"))
+	if len(docs) != 1 {
+		t.Fatalf("%q", docs)
+	}
+	if actual := string(Header(docs[0])); actual != "# header" {
+		t.Fatalf("%q", actual)
+	}
+	if actual := string(Footer(docs[0])); actual != "# kubesec:v:3\n# kubesec:mac:x" {
+		t.Fatalf("%q", actual)
+	}
+}